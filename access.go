@@ -0,0 +1,159 @@
+package master
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// accessList is the client IPs allowed by app_access_allow. The items are
+// the IP ranges in the format of acl_master such as "127.0.0.1:127.0.0.255",
+// or "all" for any IP, and the CIDR such as "10.0.0.0/8" or the single IP
+// is also supported.
+type accessList struct {
+	all    bool
+	ranges []ipRange
+	nets   []*net.IPNet
+}
+
+// ipRange is the IPs from from to to, both are in the 16-byte form.
+type ipRange struct {
+	from net.IP
+	to   net.IP
+}
+
+// parseAccess parses the value of app_access_allow, any IP is allowed if
+// the value is empty.
+func parseAccess(value string) (*accessList, error) {
+	a := &accessList{}
+	items := splitList(value)
+	if len(items) == 0 {
+		a.all = true
+		return a, nil
+	}
+
+	for _, item := range items {
+		if strings.EqualFold(item, "all") {
+			a.all = true
+			continue
+		}
+
+		if strings.IndexByte(item, '/') >= 0 {
+			_, ipnet, err := net.ParseCIDR(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", item)
+			}
+			a.nets = append(a.nets, ipnet)
+			continue
+		}
+
+		if ip := net.ParseIP(item); ip != nil {
+			a.ranges = append(a.ranges, ipRange{from: ip.To16(), to: ip.To16()})
+			continue
+		}
+
+		pos := strings.IndexByte(item, ':')
+		if pos < 0 {
+			return nil, fmt.Errorf("invalid IP range %q", item)
+		}
+
+		from := net.ParseIP(strings.TrimSpace(item[:pos])).To4()
+		to := net.ParseIP(strings.TrimSpace(item[pos+1:])).To4()
+		if from == nil || to == nil {
+			return nil, fmt.Errorf("invalid IP range %q, from:to of IPv4 expected", item)
+		}
+		if bytes.Compare(from, to) > 0 {
+			return nil, fmt.Errorf("invalid IP range %q, from > to", item)
+		}
+		a.ranges = append(a.ranges, ipRange{from: from.To16(), to: to.To16()})
+	}
+	return a, nil
+}
+
+// allow checks if the IP is allowed, the nil accessList allows any IP.
+func (a *accessList) allow(ip net.IP) bool {
+	if a == nil || a.all {
+		return true
+	}
+
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return false
+	}
+	for _, r := range a.ranges {
+		if bytes.Compare(ip16, r.from) >= 0 && bytes.Compare(ip16, r.to) <= 0 {
+			return true
+		}
+	}
+	for _, ipnet := range a.nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkAccess(value string) error {
+	_, err := parseAccess(value)
+	return err
+}
+
+// accessListener closes the connections from the client IPs not allowed
+// by app_access_allow before they're handled by the services, and counts
+// the connections accepted for app_use_limit and app_idle_limit, so they
+// work with the listeners returned by ServiceInit too.
+type accessListener struct {
+	net.Listener
+	server *Server
+}
+
+func (l *accessListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.server.allowConn(conn) {
+			l.server.accepted()
+			return conn, nil
+		}
+		_ = conn.Close()
+	}
+}
+
+// accessListeners wraps the listeners with the access control.
+func (s *Server) accessListeners(listeners []net.Listener) []net.Listener {
+	wrapped := make([]net.Listener, 0, len(listeners))
+	for _, ln := range listeners {
+		wrapped = append(wrapped, &accessListener{Listener: ln, server: s})
+	}
+	return wrapped
+}
+
+// allowConn checks if the client of the connection is allowed, the
+// connections not from TCP such as unix domain socket are always allowed.
+func (s *Server) allowConn(conn net.Conn) bool {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || s.getSettings().access.allow(addr.IP) {
+		return true
+	}
+
+	n := atomic.AddInt32(&s.rejected, 1)
+	log.Printf("pid=%d: reject %s by app_access_allow, rejected=%d",
+		os.Getpid(), addr, n)
+	return false
+}
+
+// ConnRejectedCount returns the count of the connections rejected by
+// app_access_allow.
+func (s *Server) ConnRejectedCount() int {
+	return int(atomic.LoadInt32(&s.rejected))
+}
+
+func ConnRejectedCount() int {
+	return defaultServer.ConnRejectedCount()
+}
@@ -0,0 +1,110 @@
+package master
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAccessList(t *testing.T) {
+	tests := []struct {
+		value string
+		ip    string
+		allow bool
+	}{
+		{"", "10.1.1.1", true},
+		{"all", "10.1.1.1", true},
+		{"127.0.0.1:255.255.255.255", "127.0.0.1", true},
+		{"127.0.0.1:255.255.255.255", "10.1.1.1", false},
+		{"127.0.0.1:127.0.0.1, 192.168.0.0:192.168.0.255", "192.168.0.20", true},
+		{"127.0.0.1:127.0.0.1, 192.168.0.0:192.168.0.255", "192.168.1.20", false},
+		{"10.0.0.0/8", "10.2.3.4", true},
+		{"10.0.0.0/8", "11.2.3.4", false},
+		{"10.0.0.0/8", "::ffff:10.2.3.4", true},
+		{"127.0.0.1:127.0.0.1", "::1", false},
+		{"::1", "::1", true},
+		{"fd00::/8; 127.0.0.1", "fd00::1", true},
+	}
+
+	for _, test := range tests {
+		a, err := parseAccess(test.value)
+		if err != nil {
+			t.Fatalf("parseAccess %q error: %s", test.value, err)
+		}
+		if allow := a.allow(net.ParseIP(test.ip)); allow != test.allow {
+			t.Fatalf("%q allow %s Got: %v, Expect: %v",
+				test.value, test.ip, allow, test.allow)
+		}
+	}
+
+	for _, value := range []string{"127.0.0.1:", "localhost",
+		"10.0.0.1:10.0.0.0", "10.0.0.0/33", "::1:::2"} {
+		if _, err := parseAccess(value); err == nil {
+			t.Fatalf("parseAccess %q ok, Expect error", value)
+		}
+	}
+}
+
+func TestAccessReject(t *testing.T) {
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone(),
+		WithOverrides("app_access_allow=10.0.0.0/8"))
+	service, err := s.TcpServiceInit("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+	service.AcceptHandler = func(conn net.Conn) {
+		t.Errorf("Connection from %s accepted", conn.RemoteAddr())
+	}
+	go service.Run()
+	defer s.Stop(true)
+
+	conn, err := net.Dial("tcp", service.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Got: %v, Expect: EOF", err)
+	}
+	if n := s.ConnRejectedCount(); n != 1 {
+		t.Fatalf("Got: %d, Expect: 1", n)
+	}
+
+	s = New(WithConfigFile("testdata/test.cf"),
+		WithOverrides("app_access_allow=127.0.0.1:10.0.0.1"))
+	if err := s.PrepareE(); err == nil {
+		t.Fatalf("PrepareE with invalid app_access_allow ok, Expect error")
+	}
+}
+
+func TestServiceInitAccess(t *testing.T) {
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone(),
+		WithOverrides("app_access_allow=10.0.0.0/8"))
+	listeners, err := s.ServiceInit("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ServiceInit error: %s", err)
+	}
+	defer s.Stop(true)
+	defer listeners[0].Close()
+
+	go func() {
+		if conn, err := listeners[0].Accept(); err == nil {
+			t.Errorf("Connection from %s accepted", conn.RemoteAddr())
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Got: %v, Expect: EOF", err)
+	}
+}
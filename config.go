@@ -1,20 +1,25 @@
 package master
 
 import (
-	"bufio"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const Version string = "1.1.2"
 
 type Config struct {
 	Entries map[string]string
+
+	name     string    // the name of the service block
+	sections []*Config // the service blocks in the configure file
+	env      *Config   // the entries of master_env
+	sources  map[string]Source
+	secrets  map[string]bool // the entries whose values are secrets
 }
 
 // from configure file of the app
@@ -30,6 +35,7 @@ var (
 	AppReusePort   = false
 	AppQuickAbort  = false
 	AppWaitLimit   = 10
+	AppForceQuit   = true
 	AppAccessAllow = "all"
 	Appthreads     = 0
 
@@ -37,96 +43,246 @@ var (
 	TlsKeyFile  string
 )
 
-func loadConf(confPath string) {
-	AppConf = new(Config)
-	AppConf.InitConfig(confPath)
-
-	AppLogPath = AppConf.GetString("master_log")
-	if len(AppLogPath) > 0 {
-		f, err := os.OpenFile(AppLogPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0643)
-		if err != nil {
-			fmt.Printf("open %s error %s\r\n", AppLogPath, err.Error())
-		} else {
-			log.SetOutput(f)
-			//log.SetOutput(io.MultiWriter(os.Stderr, f))
-		}
+var logFile *os.File
+
+// readConf loads the configure file, selects the service block for the
+// service, decodes the entries of master_env, overrides the entries with
+// the GOSERVICE_<KEY> environment variables and -o key=value args, and
+// resolves the secrets at last.
+func (s *Server) readConf() (*Config, error) {
+	conf, err := LoadConfig(s.confPath)
+	if err != nil {
+		return nil, err
 	}
 
-	AppService = AppConf.GetString("master_service")
-	AppOwner = AppConf.GetString("master_owner")
-	AppArgs = AppConf.GetString("master_args")
-	AppReusePort = AppConf.GetBool("master_reuseport")
+	conf = conf.serviceConf(s.service)
+	conf.env = decodeEnv(conf)
+	if s.envOverlay || s.isDefault && AppEnvOverlay {
+		conf.overlay(conf.env.Entries, SourceMasterEnv)
+	}
+
+	conf.overlay(envOverrides(), SourceEnv)
 
-	AppRootDir = AppConf.GetString("app_queue_dir")
-	AppUseLimit = AppConf.GetInt("app_use_limit")
-	AppIdleLimit = AppConf.GetInt("app_idle_limit")
-	AppQuickAbort = AppConf.GetBool("app_quick_abort")
-	AppWaitLimit = AppConf.GetInt("app_wait_limit")
-	AppAccessAllow = AppConf.GetString("app_access_allow")
-	Appthreads = AppConf.GetInt("app_threads")
-	if Appthreads > -1 {
-		runtime.GOMAXPROCS(Appthreads)
+	entries, err := flagOverrides(s.overrides)
+	if err != nil {
+		return nil, err
 	}
+	conf.overlay(entries, SourceFlag)
 
-	TlsCertFile = AppConf.GetString("tls_cert_file")
-	TlsKeyFile = AppConf.GetString("tls_key_file")
+	if err := conf.resolveSecrets(); err != nil {
+		return nil, err
+	}
 
-	log.Printf("AppArgs: %s, AppAccessAllow: %s\r\n", AppArgs, AppAccessAllow)
+	// The invalid access control isn't allowed to avoid allowing any IP.
+	if err := checkAccess(conf.GetString("app_access_allow")); err != nil {
+		return nil, fmt.Errorf("app_access_allow: %s", err)
+	}
+	return conf, nil
 }
 
-func (c *Config) InitConfig(path string) {
-	c.Entries = make(map[string]string)
+func (s *Server) loadConf() error {
+	conf, err := s.readConf()
+	if err != nil {
+		return err
+	}
 
-	if len(path) == 0 {
+	s.confMutex.Lock()
+	s.conf = conf
+
+	st := &s.settings
+	st.service = conf.GetString("master_service")
+	st.owner = conf.GetString("master_owner")
+	st.args = conf.GetString("master_args")
+	st.reusePort = conf.GetBool("master_reuseport")
+	st.rootDir = conf.GetString("app_queue_dir")
+
+	st.tlsCertFile = conf.GetString("tls_cert_file")
+	st.tlsKeyFile = conf.GetString("tls_key_file")
+	st.unixOwner = conf.GetString("app_unix_owner")
+	st.unixMode = 0
+	if mode, err := strconv.ParseUint(conf.GetString("app_unix_mode"), 8, 32); err == nil {
+		st.unixMode = os.FileMode(mode)
+	}
+
+	s.applyConf(conf)
+	s.confMutex.Unlock()
+
+	validateConf(conf)
+
+	log.Printf("AppArgs: %s, AppAccessAllow: %s\r\n",
+		conf.Redacted("master_args"), st.accessAllow)
+	return nil
+}
+
+// applyConf applies the settings which can be changed when the process is
+// running, it's called when loading or reloading the configure with the
+// confMutex locked. The log file and the threads are only set by the
+// default Server, because they're shared by the whole process.
+func (s *Server) applyConf(conf *Config) {
+	st := &s.settings
+	st.logPath = conf.GetString("master_log")
+	if s.isDefault && len(st.logPath) > 0 {
+		// Reopen the log file even if it's not changed, so the log file
+		// can be rotated by reloading the configure.
+		openLog(st.logPath)
+	}
+
+	st.useLimit = conf.GetInt("app_use_limit")
+	st.idleLimit = conf.GetInt("app_idle_limit")
+	st.busyLimit = conf.GetInt("app_busy_limit")
+	st.quickAbort = conf.GetBool("app_quick_abort")
+	st.waitLimit = conf.GetInt("app_wait_limit")
+	st.forceQuit = conf.GetBoolDefault("app_force_quit", true)
+	st.statusNotify = conf.GetBool("master_status_notify")
+	st.accessAllow = conf.GetString("app_access_allow")
+	st.access, _ = parseAccess(st.accessAllow)
+	st.threads = conf.GetInt("app_threads")
+
+	if s.isDefault {
+		if st.threads > -1 {
+			runtime.GOMAXPROCS(st.threads)
+		}
+		s.publish()
+	}
+}
+
+// publish sets the package level variables with the configure of the
+// default Server.
+func (s *Server) publish() {
+	st := &s.settings
+	AppConf = s.conf
+	AppService = st.service
+	AppLogPath = st.logPath
+	AppOwner = st.owner
+	AppArgs = st.args
+	AppRootDir = st.rootDir
+	AppUseLimit = st.useLimit
+	AppIdleLimit = st.idleLimit
+	AppReusePort = st.reusePort
+	AppQuickAbort = st.quickAbort
+	AppWaitLimit = st.waitLimit
+	AppForceQuit = st.forceQuit
+	AppAccessAllow = st.accessAllow
+	Appthreads = st.threads
+	TlsCertFile = st.tlsCertFile
+	TlsKeyFile = st.tlsKeyFile
+}
+
+func openLog(path string) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0643)
+	if err != nil {
+		fmt.Printf("open %s error %s\r\n", path, err.Error())
 		return
 	}
 
+	log.SetOutput(f)
+	//log.SetOutput(io.MultiWriter(os.Stderr, f))
+
+	if logFile != nil {
+		_ = logFile.Close()
+	}
+	logFile = f
+}
+
+// LoadConfig loads the configure from the file, the *ParseError with the
+// file name and line number will be returned for the invalid content. An
+// empty configure will be returned if path is empty. The file is decoded
+// by the decoder registered for its extension, such as ".json", ".yaml"
+// and ".toml", or parsed in the format of acl_master.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{Entries: make(map[string]string)}
+	if len(path) == 0 {
+		return c, nil
+	}
+
+	if decode := getDecoder(path); decode != nil {
+		if err := c.loadDecoded(path, decode); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	defer f.Close()
 
-	r := bufio.NewReader(f)
-	for {
-		line, _, err := r.ReadLine()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			panic(err)
-		}
-
-		s := strings.TrimSpace(string(line))
-		eq := strings.Index(s, "=")
-		if eq < 0 {
-			continue
-		}
+	if err := c.parseConf(f, path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-		name := strings.TrimSpace(s[:eq])
-		if len(name) == 0 {
-			continue
-		}
+// InitConfig loads the configure from the file just like LoadConfig, but
+// it panics when any error happens.
+func (c *Config) InitConfig(path string) {
+	conf, err := LoadConfig(path)
+	if err != nil {
+		panic(err)
+	}
+	*c = *conf
+}
 
-		value := strings.TrimSpace(s[eq+1:])
+// Sections returns the names of the service blocks in the configure file,
+// such as "server" for the block beginning with "service server {".
+func (c *Config) Sections() []string {
+	names := make([]string, 0, len(c.sections))
+	for _, section := range c.sections {
+		names = append(names, section.name)
+	}
+	return names
+}
 
-		pos := strings.Index(value, "\t#")
-		if pos > -1 {
-			value = value[0:pos]
+// Section returns the configure of the service block with the given name,
+// the entries out of any service block are also included. nil will be
+// returned if the block doesn't exist.
+func (c *Config) Section(name string) *Config {
+	for _, section := range c.sections {
+		if section.name == name {
+			return section
 		}
+	}
+	return nil
+}
 
-		pos = strings.Index(value, " #")
-		if pos > -1 {
-			value = value[0:pos]
+// serviceConf selects the configure of the service block for the service
+// name given by acl_master with -n, which may be the block name or the
+// master_service of the block. If there's only one block it'll be used,
+// and if none block matches, all the entries will be used as before.
+func (c *Config) serviceConf(service string) *Config {
+	if len(c.sections) == 0 {
+		return c
+	}
+
+	if len(service) > 0 {
+		if section := c.Section(service); section != nil {
+			return section
 		}
 
-		if len(value) == 0 {
-			continue
+		for _, section := range c.sections {
+			if sameAddrs(section.GetString("master_service"), service) {
+				return section
+			}
 		}
+	}
 
-		c.Entries[name] = strings.TrimSpace(value)
+	if len(c.sections) == 1 {
+		return c.sections[0]
 	}
+
+	log.Printf("No service block matches service=%s in %d blocks",
+		service, len(c.sections))
+	return c
+}
+
+// sameAddrs checks if the two service addrs are same regardless of the
+// blanks in them.
+func sameAddrs(a, b string) bool {
+	a = strings.Join(strings.Fields(a), "")
+	b = strings.Join(strings.Fields(b), "")
+	return len(a) > 0 && a == b
 }
 
 func (c Config) GetString(name string) string {
@@ -150,20 +306,143 @@ func (c Config) GetInt(name string) int {
 	}
 }
 
+// GetBool returns the bool value of the entry just like GetBoolDefault,
+// false is returned for the unset or invalid entry.
 func (c Config) GetBool(name string) bool {
+	return c.GetBoolDefault(name, false)
+}
+
+// Has checks if the entry exists, so the unset entry can be distinguished
+// from the entry set with zero value.
+func (c Config) Has(name string) bool {
+	_, found := c.Entries[name]
+	return found
+}
+
+// GetFloat returns the float value of the entry, 0 for the unset or invalid
+// entry.
+func (c Config) GetFloat(name string) float64 {
+	return c.GetFloatDefault(name, 0)
+}
+
+// GetDuration returns the duration value of the entry such as "30s" or
+// "1m30s", the value without unit is in seconds. 0 is returned for the unset
+// or invalid entry.
+func (c Config) GetDuration(name string) time.Duration {
+	return c.GetDurationDefault(name, 0)
+}
+
+// GetSize returns the size in bytes of the entry such as "8192", "512K",
+// "512M" or "1G". 0 is returned for the unset or invalid entry.
+func (c Config) GetSize(name string) int64 {
+	return c.GetSizeDefault(name, 0)
+}
+
+// GetStrings splits the value of the entry by any char in sep, such as ","
+// for "127.0.0.1:8080, 127.0.0.1:8081". If sep is empty, the value will be
+// split by ',' or ';'. The blanks around the items are trimmed and the empty
+// items are skipped.
+func (c Config) GetStrings(name string, sep string) []string {
+	return c.GetStringsDefault(name, sep, nil)
+}
+
+// GetMap returns the map of the entry like "k1:v1, k2:v2" as master_env.
+func (c Config) GetMap(name string) map[string]string {
+	return c.GetMapDefault(name, nil)
+}
+
+// GetStringDefault returns def if the entry isn't set.
+func (c Config) GetStringDefault(name string, def string) string {
 	val, found := c.Entries[name]
 	if !found {
-		return false
+		return def
 	}
+	return val
+}
 
-	if val == "yes" || val == "true" || val == "y" {
-		return true
+// GetIntDefault returns def if the entry isn't set or isn't an integer.
+func (c Config) GetIntDefault(name string, def int) int {
+	val, found := c.Entries[name]
+	if !found {
+		return def
 	}
-
 	n, err := strconv.Atoi(val)
 	if err != nil {
-		return false
-	} else {
-		return n != 0
+		return def
+	}
+	return n
+}
+
+// GetBoolDefault returns def if the entry isn't set or isn't a bool, the
+// bool value may be yes/no, true/false, y/n, on/off or number.
+func (c Config) GetBoolDefault(name string, def bool) bool {
+	val, found := c.Entries[name]
+	if !found {
+		return def
+	}
+	b, err := parseBool(val)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// GetFloatDefault returns def if the entry isn't set or isn't a float.
+func (c Config) GetFloatDefault(name string, def float64) float64 {
+	val, found := c.Entries[name]
+	if !found {
+		return def
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// GetDurationDefault returns def if the entry isn't set or isn't a duration.
+func (c Config) GetDurationDefault(name string, def time.Duration) time.Duration {
+	val, found := c.Entries[name]
+	if !found {
+		return def
+	}
+	d, err := parseDuration(val)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// GetStringsDefault returns def if the entry isn't set, see GetStrings.
+func (c Config) GetStringsDefault(name string, sep string, def []string) []string {
+	val, found := c.Entries[name]
+	if !found {
+		return def
+	}
+	if len(sep) == 0 {
+		return splitList(val)
+	}
+	return splitBy(val, sep)
+}
+
+// GetMapDefault returns def if the entry isn't set, see GetMap.
+func (c Config) GetMapDefault(name string, def map[string]string) map[string]string {
+	val, found := c.Entries[name]
+	if !found {
+		return def
+	}
+	return parseMap(val)
+}
+
+// GetSizeDefault returns def if the entry isn't set or isn't a size.
+func (c Config) GetSizeDefault(name string, def int64) int64 {
+	val, found := c.Entries[name]
+	if !found {
+		return def
+	}
+	n, err := parseSize(val)
+	if err != nil {
+		return def
 	}
+	return n
 }
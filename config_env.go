@@ -0,0 +1,35 @@
+package master
+
+import "os"
+
+// serviceEnvName is the environment variable which acl_master sets for the
+// child process with the value of master_env, such as:
+// "mempool_limit:512000000, mempool_use_mutex:true".
+const serviceEnvName = "SERVICE_ENV"
+
+// AppEnvOverlay if it's set true before Prepare, the entries decoded from
+// master_env will overlay the entries of the configure with the same names.
+var AppEnvOverlay = false
+
+// Env returns the entries of master_env given by acl_master to the current
+// process, which are decoded from the SERVICE_ENV environment variable. In
+// alone mode, the entries are decoded from the master_env in the configure
+// file instead. An empty configure is returned before Prepare.
+func Env() *Config {
+	conf := CurrentConfig()
+	if conf == nil || conf.env == nil {
+		return &Config{Entries: make(map[string]string)}
+	}
+	return conf.env
+}
+
+// decodeEnv decodes the entries of master_env for the configure of the
+// service from the environment, or from the configure itself if acl_master
+// doesn't give them.
+func decodeEnv(conf *Config) *Config {
+	value, found := os.LookupEnv(serviceEnvName)
+	if !found {
+		value = conf.GetString("master_env")
+	}
+	return &Config{Entries: parseMap(value)}
+}
@@ -0,0 +1,66 @@
+package master
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDecodeEnv(t *testing.T) {
+	saved, found := os.LookupEnv(serviceEnvName)
+	defer func() {
+		if found {
+			os.Setenv(serviceEnvName, saved)
+		} else {
+			os.Unsetenv(serviceEnvName)
+		}
+	}()
+
+	myConf := new(Config)
+	myConf.InitConfig("testdata/test.cf")
+
+	// In alone mode, master_env in the configure file is used.
+	os.Unsetenv(serviceEnvName)
+	env := decodeEnv(myConf)
+	if env.GetInt("mempool_limit") != 512000000 || !env.GetBool("mempool_use_mutex") {
+		t.Fatalf("Unexpected env from configure: %v", env.Entries)
+	}
+
+	// The encoding of acl_master: "k:v" pairs separated by ',' or ';'.
+	os.Setenv(serviceEnvName, "logme:FALSE, priority:E_LOG_INFO; "+
+		"action:E_LOG_PER_DAY,flush:sync_flush, sem_name:/tmp/aio_echo.sem, debug")
+	env = decodeEnv(myConf)
+
+	expect := map[string]string{
+		"logme":    "FALSE",
+		"priority": "E_LOG_INFO",
+		"action":   "E_LOG_PER_DAY",
+		"flush":    "sync_flush",
+		"sem_name": "/tmp/aio_echo.sem",
+		"debug":    "",
+	}
+	if len(env.Entries) != len(expect) {
+		t.Fatalf("Got: %v, Expect: %v", env.Entries, expect)
+	}
+	for key, value := range expect {
+		if !env.Has(key) || env.GetString(key) != value {
+			t.Fatalf("Got: %s=%s, Expect: %s", key, env.GetString(key), value)
+		}
+	}
+
+	os.Setenv(serviceEnvName, "client_idle_limit:120")
+	conf, err := New(WithConfigFile("testdata/test.cf")).readConf()
+	if err != nil {
+		t.Fatalf("readConf error: %s", err)
+	}
+	if conf.GetInt("client_idle_limit") != 60 || conf.env.GetInt("client_idle_limit") != 120 {
+		t.Fatalf("Got: %s, Expect not overlaid", conf.GetString("client_idle_limit"))
+	}
+
+	conf, err = New(WithConfigFile("testdata/test.cf"), WithEnvOverlay()).readConf()
+	if err != nil {
+		t.Fatalf("readConf error: %s", err)
+	}
+	if conf.GetInt("client_idle_limit") != 120 {
+		t.Fatalf("Got: %s, Expect overlaid 120", conf.GetString("client_idle_limit"))
+	}
+}
@@ -0,0 +1,277 @@
+package master
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ConfFile is the configure file kept with its comments, blank lines,
+// entries' order and service blocks, so the tools can modify the entries
+// and write it back without losing the formatting. The variables and the
+// include directives are kept as they are.
+type ConfFile struct {
+	name  string
+	nodes []*confNode
+}
+
+// confNode is one line of the configure file, or the lines joined by '\'.
+type confNode struct {
+	raw     []string
+	kind    lineKind
+	section string // the service block the node is in, "" for out of any block
+	key     string
+	value   string
+}
+
+// LoadConfFile loads the configure file for modifying.
+func LoadConfFile(path string) (*ConfFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ParseConfFile(f, path)
+}
+
+// ParseConfFile parses the configure file from r, the name is used in the
+// errors.
+func ParseConfFile(r io.Reader, name string) (*ConfFile, error) {
+	cf := &ConfFile{name: name}
+	var (
+		section string
+		inBlock bool
+		begin   int
+	)
+
+	lr := newLineReader(r)
+	for {
+		text, num, ok := lr.next()
+		if !ok {
+			break
+		}
+
+		line := parseLine(text)
+		node := &confNode{
+			raw:     append([]string(nil), lr.raw...),
+			kind:    line.kind,
+			section: section,
+			key:     line.key,
+			value:   line.value,
+		}
+
+		switch line.kind {
+		case lineBlockBegin:
+			if inBlock {
+				return nil, &ParseError{File: name, Line: num,
+					Msg: fmt.Sprintf("service %s begins in service %s", line.name, section)}
+			}
+			section, inBlock, begin = line.name, true, num
+			node.section = section
+		case lineBlockEnd:
+			if !inBlock {
+				return nil, &ParseError{File: name, Line: num, Msg: "unexpected '}'"}
+			}
+			section, inBlock = "", false
+		}
+
+		cf.nodes = append(cf.nodes, node)
+	}
+
+	if err := lr.err(); err != nil {
+		return nil, &ParseError{File: name, Line: lr.num + 1, Msg: err.Error()}
+	}
+	if inBlock {
+		return nil, &ParseError{File: name, Line: begin,
+			Msg: fmt.Sprintf("service %s isn't closed by '}'", section)}
+	}
+	return cf, nil
+}
+
+// Sections returns the names of the service blocks.
+func (cf *ConfFile) Sections() []string {
+	var names []string
+	for _, node := range cf.nodes {
+		if node.kind == lineBlockBegin {
+			names = append(names, node.section)
+		}
+	}
+	return names
+}
+
+// Get returns the value of the entry in the service block named section,
+// the section "" is for the entries out of any service block.
+func (cf *ConfFile) Get(section, key string) (string, bool) {
+	for i := len(cf.nodes) - 1; i >= 0; i-- {
+		node := cf.nodes[i]
+		if node.kind == lineEntry && node.section == section && node.key == key {
+			return node.value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets the value of the entry in the service block named section, the
+// section "" is for the entries out of any service block. The indent and the
+// comment of the entry are kept if it exists, or it'll be added at the end
+// of the service block, and the service block will be added at the end of
+// the file if it doesn't exist.
+func (cf *ConfFile) Set(section, key, value string) error {
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+	if len(key) == 0 || strings.ContainsAny(key, "=#\n") {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("value should be in one line")
+	}
+	// The rest after " #" or "\t#" is read as the comment.
+	if strings.Contains(value, " #") || strings.Contains(value, "\t#") {
+		return fmt.Errorf("value %q has the comment", value)
+	}
+
+	for i := len(cf.nodes) - 1; i >= 0; i-- {
+		node := cf.nodes[i]
+		if node.kind == lineEntry && node.section == section && node.key == key {
+			node.raw = []string{renderEntry(node, key, value)}
+			node.value = value
+			return nil
+		}
+	}
+
+	pos, indent, found := cf.insertPos(section)
+	node := &confNode{kind: lineEntry, section: section, key: key, value: value}
+	node.raw = []string{indent + key + " = " + value}
+
+	if !found {
+		node.raw = []string{"\t" + key + " = " + value}
+		cf.nodes = append(cf.nodes,
+			&confNode{raw: []string{""}, kind: lineBlank},
+			&confNode{raw: []string{"service " + section + " {"}, kind: lineBlockBegin, section: section},
+			node,
+			&confNode{raw: []string{"}"}, kind: lineBlockEnd, section: section})
+		return nil
+	}
+
+	cf.nodes = append(cf.nodes, nil)
+	copy(cf.nodes[pos+1:], cf.nodes[pos:])
+	cf.nodes[pos] = node
+	return nil
+}
+
+// Delete deletes the entry in the service block named section, and returns
+// false if it doesn't exist.
+func (cf *ConfFile) Delete(section, key string) bool {
+	found := false
+	nodes := cf.nodes[:0]
+	for _, node := range cf.nodes {
+		if node.kind == lineEntry && node.section == section && node.key == key {
+			found = true
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	cf.nodes = nodes
+	return found
+}
+
+// WriteTo writes the configure file to w.
+func (cf *ConfFile) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	for _, node := range cf.nodes {
+		for _, line := range node.raw {
+			m, err := bw.WriteString(line + "\n")
+			n += int64(m)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, bw.Flush()
+}
+
+// insertPos returns the position to insert the new entry in the section
+// and the indent of it. The new entry of the service block is inserted
+// before the end of the block; the one out of any block is inserted after
+// the last entry out of any block, or before the first block and the
+// comments above it.
+func (cf *ConfFile) insertPos(section string) (int, string, bool) {
+	if len(section) > 0 {
+		indent := "\t"
+		for i, node := range cf.nodes {
+			if node.section != section {
+				continue
+			}
+			if node.kind == lineEntry {
+				indent = leadingSpace(node.raw[0])
+			}
+			if node.kind == lineBlockEnd {
+				return i, indent, true
+			}
+		}
+		return 0, "", false
+	}
+
+	last, first := -1, -1
+	for i, node := range cf.nodes {
+		if node.section != "" {
+			if first < 0 && node.kind == lineBlockBegin {
+				first = i
+			}
+			continue
+		}
+		if node.kind == lineEntry {
+			last = i
+		}
+	}
+
+	switch {
+	case last >= 0:
+		return last + 1, leadingSpace(cf.nodes[last].raw[0]), true
+	case first >= 0:
+		for first > 0 && cf.nodes[first-1].kind == lineComment {
+			first--
+		}
+		return first, "", true
+	default:
+		return len(cf.nodes), "", true
+	}
+}
+
+// renderEntry renders the entry with the new value, and keeps the indent,
+// the blanks around '=' and the comment after the value.
+func renderEntry(node *confNode, key, value string) string {
+	raw := node.raw[0]
+	eq := strings.Index(raw, "=")
+	if len(node.raw) > 1 || eq < 0 {
+		return leadingSpace(raw) + key + " = " + value
+	}
+
+	after := raw[eq+1:]
+	rest := strings.TrimLeft(after, " \t")
+	blank := after[:len(after)-len(rest)]
+	if len(blank) == 0 && eq > 0 && raw[eq-1] == ' ' {
+		blank = " "
+	}
+
+	comment := ""
+	pos := strings.Index(rest, "\t#")
+	if p := strings.Index(rest, " #"); p > -1 && (pos < 0 || p < pos) {
+		pos = p
+	}
+	if pos > -1 {
+		comment = rest[len(strings.TrimRight(rest[:pos], " \t")):]
+	}
+
+	return raw[:eq+1] + blank + value + comment
+}
+
+func leadingSpace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
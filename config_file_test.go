@@ -0,0 +1,88 @@
+package master
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfFileRoundTrip(t *testing.T) {
+	for _, path := range []string{"testdata/test.cf", "testdata/multi.cf", "testdata/include/main.cf"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cf, err := ParseConfFile(bytes.NewReader(data), path)
+		if err != nil {
+			t.Fatalf("ParseConfFile %s error: %s", path, err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := cf.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo error: %s", err)
+		}
+		if buf.String() != string(data) {
+			t.Fatalf("Got:\n%s\nExpect:\n%s", buf.String(), data)
+		}
+	}
+}
+
+func TestConfFileModify(t *testing.T) {
+	cf, err := LoadConfFile("testdata/multi.cf")
+	if err != nil {
+		t.Fatalf("LoadConfFile error: %s", err)
+	}
+
+	if err := cf.Set("httpd", "app_wait_limit", "30"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.Set("echo", "master_maxproc", "8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.Set("", "master_owner", "nobody"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.Set("proxy", "master_service", "127.0.0.1|9001"); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"x #y", "x\t#y", "x\ny"} {
+		if err := cf.Set("echo", "master_args", value); err == nil {
+			t.Fatalf("Set %q ok, Expect error", value)
+		}
+	}
+	if !cf.Delete("echo", "client_idle_limit") || cf.Delete("echo", "client_idle_limit") {
+		t.Fatalf("Delete client_idle_limit error")
+	}
+
+	var buf bytes.Buffer
+	if _, err := cf.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, expect := range []string{
+		"\tapp_wait_limit = 30\t# wait 10 seconds at most\n",
+		"\tapp_wait_limit = 5\n\tmaster_maxproc = 8\n}\n",
+		"master_log = /opt/soft/acl-master/var/log/multi.log\nmaster_owner = nobody\n",
+		"\nservice proxy {\n\tmaster_service = 127.0.0.1|9001\n}\n",
+		"#\t服务地址及端口号\n",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Fatalf("Got:\n%s\nExpect contains: %q", out, expect)
+		}
+	}
+	if strings.Contains(out, "client_idle_limit") {
+		t.Fatalf("Got:\n%s\nExpect client_idle_limit deleted", out)
+	}
+
+	conf := &Config{Entries: make(map[string]string)}
+	if err := conf.parseConf(&buf, "multi.cf"); err != nil {
+		t.Fatalf("Parse modified file error: %s", err)
+	}
+	if conf.Section("echo").GetInt("master_maxproc") != 8 ||
+		conf.Section("proxy").GetString("master_owner") != "nobody" {
+		t.Fatalf("Unexpected entries: %v", conf.Entries)
+	}
+}
@@ -0,0 +1,182 @@
+package master
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DecodeFunc decodes the content of the configure file into the nested
+// map, which will be flattened into the entries of Config.
+type DecodeFunc func(data []byte) (map[string]interface{}, error)
+
+var (
+	decoderMutex sync.RWMutex
+	decoders     = map[string]DecodeFunc{
+		".json": decodeJSON,
+		".yaml": decodeYAML,
+		".yml":  decodeYAML,
+		".toml": decodeTOML,
+	}
+)
+
+// RegisterDecoder registers the decoder for the configure files with the
+// extension such as ".json", the files with the extensions without decoder
+// are parsed in the format of acl_master.
+func RegisterDecoder(ext string, decoder DecodeFunc) {
+	decoderMutex.Lock()
+	decoders[strings.ToLower(ext)] = decoder
+	decoderMutex.Unlock()
+}
+
+func getDecoder(path string) DecodeFunc {
+	decoderMutex.RLock()
+	defer decoderMutex.RUnlock()
+	return decoders[strings.ToLower(filepath.Ext(path))]
+}
+
+func decodeJSON(data []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	err := json.Unmarshal(data, &m)
+	return m, err
+}
+
+func decodeYAML(data []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	err := yaml.Unmarshal(data, &m)
+	return m, err
+}
+
+func decodeTOML(data []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	err := toml.Unmarshal(data, &m)
+	return m, err
+}
+
+// loadDecoded loads the configure file with the decoder. The nested keys
+// are flattened with '_' just like the keys of acl_master, for example,
+// "master: {log: /var/log/app.log}" in YAML is flattened to master_log.
+// The tables in the top level "service" table are the service blocks
+// like "service name {...}" in the configure file of acl_master.
+func (c *Config) loadDecoded(path string, decode DecodeFunc) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	m, err := decode(data)
+	if err != nil {
+		return &ParseError{File: path, Msg: err.Error()}
+	}
+
+	globals := make(map[string]string)
+	services, _ := m["service"].(map[string]interface{})
+	if services != nil {
+		delete(m, "service")
+	}
+	if err := flatten(globals, "", m); err != nil {
+		return &ParseError{File: path, Msg: err.Error()}
+	}
+	for key, value := range globals {
+		c.Entries[key] = value
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entries, ok := services[name].(map[string]interface{})
+		if !ok {
+			return &ParseError{File: path, Msg: fmt.Sprintf("service %s isn't a table", name)}
+		}
+
+		section := &Config{Entries: make(map[string]string), name: name}
+		if err := flatten(section.Entries, "", entries); err != nil {
+			return &ParseError{File: path, Msg: fmt.Sprintf("service %s: %s", name, err)}
+		}
+		for key, value := range globals {
+			if _, found := section.Entries[key]; !found {
+				section.Entries[key] = value
+			}
+		}
+
+		for key, value := range section.Entries {
+			c.Entries[key] = value
+		}
+		c.sections = append(c.sections, section)
+	}
+	return nil
+}
+
+// flatten flattens the nested map m into entries, the keys of the nested
+// tables are joined with '_', and the '.' in the keys is replaced with '_'.
+func flatten(entries map[string]string, prefix string, m map[string]interface{}) error {
+	for key, val := range m {
+		name := prefix + strings.Replace(key, ".", "_", -1)
+		if nested, ok := val.(map[string]interface{}); ok {
+			if err := flatten(entries, name+"_", nested); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, err := formatValue(val)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if len(value) > 0 {
+			entries[name] = value
+		}
+	}
+	return nil
+}
+
+// formatValue formats the value in the way of acl_master, such as "yes"
+// for true, and "a, b, c" for the list.
+func formatValue(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		if v {
+			return "yes", nil
+		}
+		return "no", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := formatValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ", "), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", val)
+	}
+}
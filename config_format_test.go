@@ -0,0 +1,30 @@
+package master
+
+import "testing"
+
+func TestConfigFormats(t *testing.T) {
+	for _, path := range []string{"testdata/format.json", "testdata/format.yaml", "testdata/format.toml"} {
+		myConf, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig %s error: %s", path, err)
+		}
+
+		sections := myConf.Sections()
+		if len(sections) != 2 || sections[0] != "echo" || sections[1] != "httpd" {
+			t.Fatalf("Got: %v, Expect: [echo httpd] in %s", sections, path)
+		}
+
+		echo := myConf.serviceConf("127.0.0.1|5200")
+		if echo.GetString("master_log") != "/opt/soft/acl-master/var/log/format.log" ||
+			echo.GetInt("master_maxproc") != 5 || echo.GetInt("app_wait_limit") != 5 ||
+			!echo.GetBool("app_quick_abort") ||
+			echo.GetString("backend_addrs") != "127.0.0.1:8080, 127.0.0.1:8081" {
+			t.Fatalf("Unexpected entries of echo in %s: %v", path, echo.Entries)
+		}
+
+		httpd := myConf.serviceConf("httpd")
+		if httpd.GetString("master_service") != "127.0.0.1|8881" || httpd.GetInt("app_wait_limit") != 10 {
+			t.Fatalf("Unexpected entries of httpd in %s: %v", path, httpd.Entries)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package master
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Source is the layer where the value of one entry comes from, the value
+// from the upper layer overrides the one from the lower layer.
+type Source int
+
+const (
+	SourceNone      Source = iota // the entry isn't set
+	SourceFile                    // from the configure file
+	SourceMasterEnv               // from master_env when AppEnvOverlay is set
+	SourceEnv                     // from the GOSERVICE_<KEY> environment variable
+	SourceFlag                    // from the -o key=value command arg
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceNone:
+		return "none"
+	case SourceFile:
+		return "file"
+	case SourceMasterEnv:
+		return "master_env"
+	case SourceEnv:
+		return "env"
+	case SourceFlag:
+		return "flag"
+	default:
+		return fmt.Sprintf("Source(%d)", int(s))
+	}
+}
+
+// envPrefix is the prefix of the environment variables overriding the
+// entries, such as GOSERVICE_APP_WAIT_LIMIT for app_wait_limit.
+const envPrefix = "GOSERVICE_"
+
+// overrideFlag is used to register -o for flag.Parse, which can be given
+// more than once.
+type overrideFlag []string
+
+func (f *overrideFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *overrideFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Source returns the layer where the value of the entry comes from.
+func (c *Config) Source(name string) Source {
+	if source, found := c.sources[name]; found {
+		return source
+	}
+	if c.Has(name) {
+		return SourceFile
+	}
+	return SourceNone
+}
+
+// overlay sets the entries of c with the given entries from the source.
+func (c *Config) overlay(entries map[string]string, source Source) {
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	for key, value := range entries {
+		c.Entries[key] = value
+		c.sources[key] = source
+	}
+}
+
+// envOverrides returns the entries given by the GOSERVICE_<KEY> environment
+// variables, the keys are in lower case.
+func envOverrides() map[string]string {
+	entries := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+
+		pos := strings.IndexByte(kv, '=')
+		key := strings.ToLower(kv[len(envPrefix):pos])
+		if len(key) > 0 {
+			entries[key] = kv[pos+1:]
+		}
+	}
+	return entries
+}
+
+// flagOverrides returns the entries given by -o key=value.
+func flagOverrides(args []string) (map[string]string, error) {
+	entries := make(map[string]string)
+	for _, arg := range args {
+		pos := strings.IndexByte(arg, '=')
+		if pos <= 0 {
+			return nil, fmt.Errorf("invalid -o %q, key=value expected", arg)
+		}
+
+		key := strings.TrimSpace(arg[:pos])
+		if len(key) == 0 {
+			return nil, fmt.Errorf("invalid -o %q, key=value expected", arg)
+		}
+		entries[key] = strings.TrimSpace(arg[pos+1:])
+	}
+	return entries, nil
+}
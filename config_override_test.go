@@ -0,0 +1,42 @@
+package master
+
+import (
+	"os"
+	"testing"
+)
+
+func TestConfigOverrides(t *testing.T) {
+	os.Setenv("GOSERVICE_CLIENT_IDLE_LIMIT", "90")
+	os.Setenv("GOSERVICE_DEBUG_MEM", "0")
+	defer os.Unsetenv("GOSERVICE_CLIENT_IDLE_LIMIT")
+	defer os.Unsetenv("GOSERVICE_DEBUG_MEM")
+
+	conf, err := New(WithConfigFile("testdata/test.cf"),
+		WithOverrides("debug_mem=yes", "app_wait_limit = 3")).readConf()
+	if err != nil {
+		t.Fatalf("readConf error: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		value  string
+		source Source
+	}{
+		{"master_log", "/opt/soft/acl-master/var/log/aio_echo.log", SourceFile},
+		{"client_idle_limit", "90", SourceEnv},
+		{"debug_mem", "yes", SourceFlag},
+		{"app_wait_limit", "3", SourceFlag},
+		{"master_notify_addr", "", SourceNone},
+	}
+	for _, test := range tests {
+		if conf.GetString(test.name) != test.value || conf.Source(test.name) != test.source {
+			t.Fatalf("Got: %s=%s from %s, Expect: %s from %s", test.name,
+				conf.GetString(test.name), conf.Source(test.name), test.value, test.source)
+		}
+	}
+
+	s := New(WithConfigFile("testdata/test.cf"), WithArgs([]string{"-o", "app_wait_limit"}))
+	if err := s.PrepareE(); err == nil {
+		t.Fatalf("readConf with invalid -o ok, Expect error")
+	}
+}
@@ -0,0 +1,386 @@
+package master
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ParseError describes a problem in the configure file with its position.
+type ParseError struct {
+	File string // the path of the configure file
+	Line int    // the line number begin with 1, 0 if unknown
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+// maxLineSize is the max length of one line in the configure file.
+const maxLineSize = 1024 * 1024
+
+type lineKind int
+
+const (
+	lineBlank lineKind = iota
+	lineComment
+	lineEntry
+	lineBlockBegin
+	lineBlockEnd
+	lineUnknown
+)
+
+// confLine is one line of the configure file been parsed.
+type confLine struct {
+	num   int // line number begin with 1
+	kind  lineKind
+	key   string // the name of the entry for lineEntry
+	value string // the value of the entry for lineEntry
+	name  string // the service name for lineBlockBegin
+}
+
+// parseLine parses one line of the configure file which may be a
+// "key = value" entry, a "service name {" or "}" line of service block,
+// or a comment line beginning with '#'.
+func parseLine(s string) confLine {
+	s = strings.TrimSpace(s)
+	switch {
+	case len(s) == 0:
+		return confLine{kind: lineBlank}
+	case s[0] == '#':
+		return confLine{kind: lineComment}
+	case s == "}":
+		return confLine{kind: lineBlockEnd}
+	}
+
+	eq := strings.Index(s, "=")
+	if eq < 0 {
+		if strings.HasSuffix(s, "{") {
+			fields := strings.Fields(strings.TrimSuffix(s, "{"))
+			if len(fields) > 0 && fields[0] == "service" {
+				return confLine{
+					kind: lineBlockBegin,
+					name: strings.Join(fields[1:], " "),
+				}
+			}
+		}
+		return confLine{kind: lineUnknown}
+	}
+
+	name := strings.TrimSpace(s[:eq])
+	if len(name) == 0 {
+		return confLine{kind: lineUnknown}
+	}
+
+	value := strings.TrimSpace(s[eq+1:])
+
+	pos := strings.Index(value, "\t#")
+	if pos > -1 {
+		value = value[0:pos]
+	}
+
+	pos = strings.Index(value, " #")
+	if pos > -1 {
+		value = value[0:pos]
+	}
+
+	return confLine{
+		kind:  lineEntry,
+		key:   name,
+		value: strings.TrimSpace(value),
+	}
+}
+
+// lineReader reads the lines from the configure file, the lines ending
+// with '\\' are joined with the next lines except the comment lines.
+type lineReader struct {
+	scanner *bufio.Scanner
+	num     int      // the number of the lines been read
+	raw     []string // the lines joined into the last line
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	return &lineReader{scanner: scanner}
+}
+
+// next returns the next line and the number of its first line.
+func (lr *lineReader) next() (string, int, bool) {
+	if !lr.scanner.Scan() {
+		return "", 0, false
+	}
+
+	lr.num++
+	begin := lr.num
+	text := lr.scanner.Text()
+	lr.raw = append(lr.raw[:0], text)
+	if strings.HasPrefix(strings.TrimSpace(text), "#") {
+		return text, begin, true
+	}
+
+	for strings.HasSuffix(text, "\\") {
+		text = strings.TrimSuffix(text, "\\")
+		if !lr.scanner.Scan() {
+			break
+		}
+		lr.num++
+		lr.raw = append(lr.raw, lr.scanner.Text())
+		text += strings.TrimSpace(lr.scanner.Text())
+	}
+	return text, begin, true
+}
+
+func (lr *lineReader) err() error {
+	return lr.scanner.Err()
+}
+
+// confParser parses the configure file and the files included by it.
+type confParser struct {
+	conf    *Config
+	globals map[string]string
+	curr    *Config  // the current service block
+	files   []string // the files being parsed for checking include cycle
+}
+
+// parseConf parses the configure from r into c, the file is used in the
+// errors and for the relative paths of the included files. All the entries
+// are put into c.Entries just as before the service blocks were supported,
+// and the entries in each service block are put into one section, which
+// also holds the entries out of any service block.
+func (c *Config) parseConf(r io.Reader, file string) error {
+	p := &confParser{conf: c, globals: make(map[string]string)}
+	if err := p.parse(r, file); err != nil {
+		return err
+	}
+
+	for _, section := range c.sections {
+		for key, value := range p.globals {
+			if _, found := section.Entries[key]; !found {
+				section.Entries[key] = value
+			}
+		}
+	}
+	return nil
+}
+
+func (p *confParser) parse(r io.Reader, file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	for _, f := range p.files {
+		if f == abs {
+			return &ParseError{File: file, Msg: "include cycle: " +
+				strings.Join(append(p.files, abs), " -> ")}
+		}
+	}
+
+	p.files = append(p.files, abs)
+	defer func() { p.files = p.files[:len(p.files)-1] }()
+
+	// The service block begins out of the file can't be ended in it.
+	outer := p.curr
+	begin := 0
+
+	lr := newLineReader(r)
+	for {
+		text, num, ok := lr.next()
+		if !ok {
+			break
+		}
+
+		line := parseLine(text)
+		line.num = num
+
+		switch line.kind {
+		case lineUnknown:
+			// The line without '=' was skipped before, so it's only warned
+			// for the compatibility.
+			log.Printf("%s:%d: invalid line %q skipped", file, num, strings.TrimSpace(text))
+		case lineBlockBegin:
+			if p.curr != nil {
+				return &ParseError{File: file, Line: num,
+					Msg: fmt.Sprintf("service %s begins in service %s", line.name, p.curr.name)}
+			}
+			if p.conf.Section(line.name) != nil {
+				return &ParseError{File: file, Line: num,
+					Msg: fmt.Sprintf("duplicate service %s", line.name)}
+			}
+			p.curr = &Config{Entries: make(map[string]string), name: line.name}
+			p.conf.sections = append(p.conf.sections, p.curr)
+			begin = num
+		case lineBlockEnd:
+			if p.curr == nil || p.curr == outer {
+				return &ParseError{File: file, Line: num, Msg: "unexpected '}'"}
+			}
+			p.curr = nil
+		case lineEntry:
+			value := p.expand(line.value)
+			if len(value) == 0 {
+				continue
+			}
+
+			if line.key == "include" {
+				if err := p.include(file, num, value); err != nil {
+					return err
+				}
+				continue
+			}
+
+			p.conf.Entries[line.key] = value
+			if p.curr != nil {
+				p.curr.Entries[line.key] = value
+			} else {
+				p.globals[line.key] = value
+			}
+		}
+	}
+
+	if err := lr.err(); err != nil {
+		return &ParseError{File: file, Line: lr.num + 1, Msg: err.Error()}
+	}
+
+	if p.curr != outer {
+		return &ParseError{File: file, Line: begin,
+			Msg: fmt.Sprintf("service %s isn't closed by '}'", p.curr.name)}
+	}
+	return nil
+}
+
+// include parses the files matching the pattern which is relative to the
+// directory of the current file if it's not absolute. It's ok if no file
+// matches the pattern with wildcards. The errors are reported with the line
+// num of the include directive in the file.
+func (p *confParser) include(file string, num int, pattern string) error {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(file), pattern)
+	}
+
+	paths := []string{pattern}
+	if strings.ContainsAny(pattern, "*?[") {
+		var err error
+		if paths, err = filepath.Glob(pattern); err != nil {
+			return &ParseError{File: file, Line: num,
+				Msg: fmt.Sprintf("include %s: %s", pattern, err)}
+		}
+		sort.Strings(paths)
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return &ParseError{File: file, Line: num,
+				Msg: fmt.Sprintf("include %s: %s", path, err)}
+		}
+		err = p.parse(f, path)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expand replaces the variables in the value, which may be ${NAME} for the
+// environment variable NAME, or {name} for the entry name defined before
+// in the current service block or out of any service block, or for the
+// environment variable if no such entry. The default value can be given as
+// ${NAME:-default} or {name:-default}. ${NAME} will be replaced with empty
+// if NAME isn't defined, but {name} will be left as it is, so the
+// placeholders such as {install_path} can still be replaced by setup.sh.
+func (p *confParser) expand(value string) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		begin := i
+		dollar := value[i] == '$' && i+1 < len(value) && value[i+1] == '{'
+		if dollar {
+			begin++
+		}
+		if value[begin] != '{' {
+			b.WriteByte(value[i])
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(value[begin:], '}')
+		if end < 0 {
+			b.WriteString(value[i:])
+			break
+		}
+		end += begin
+
+		name, def, hasDef := splitVar(value[begin+1 : end])
+		if !isVarName(name) {
+			b.WriteByte(value[i])
+			i++
+			continue
+		}
+
+		var val string
+		var found bool
+		if dollar {
+			val, found = os.LookupEnv(name)
+		} else {
+			val, found = p.lookup(name)
+		}
+
+		switch {
+		case found:
+			b.WriteString(val)
+		case hasDef:
+			b.WriteString(def)
+		case !dollar:
+			b.WriteString(value[i : end+1])
+		}
+		i = end + 1
+	}
+	return b.String()
+}
+
+func (p *confParser) lookup(name string) (string, bool) {
+	if p.curr != nil {
+		if val, found := p.curr.Entries[name]; found {
+			return val, true
+		}
+	}
+	if val, found := p.globals[name]; found {
+		return val, true
+	}
+	return os.LookupEnv(name)
+}
+
+// splitVar splits the variable like "name:-default".
+func splitVar(s string) (string, string, bool) {
+	pos := strings.Index(s, ":-")
+	if pos < 0 {
+		return s, "", false
+	}
+	return s[:pos], s[pos+2:], true
+}
+
+func isVarName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, ch := range name {
+		if !(ch == '_' || ch == '.' || ch >= '0' && ch <= '9' ||
+			ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package master
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ConfigChangeFunc is called after the configure has been reloaded with the
+// old and new configure, Diff can be used to get the changed entries.
+type ConfigChangeFunc func(old, new *Config)
+
+// restartKeys are the entries which only take effect after restarting.
+var restartKeys = []string{
+	"master_service",
+	"master_owner",
+	"master_args",
+	"master_reuseport",
+	"app_queue_dir",
+	"tls_cert_file",
+	"tls_key_file",
+}
+
+// OnConfigChange registers the handler which will be called when the
+// configure has been reloaded and some entries changed.
+func (s *Server) OnConfigChange(handler ConfigChangeFunc) {
+	s.reloadMutex.Lock()
+	s.changeHandlers = append(s.changeHandlers, handler)
+	s.reloadMutex.Unlock()
+}
+
+// Conf returns the current configure, which may be swapped by ReloadConfig.
+func (s *Server) Conf() *Config {
+	s.confMutex.RLock()
+	conf := s.conf
+	s.confMutex.RUnlock()
+	return conf
+}
+
+// ReloadConfig reloads the configure file, swaps the configure and
+// reapplies the settings which can be changed when running, such as
+// app_wait_limit, app_access_allow and master_log; the others such as
+// master_service only take effect after restarting. The handlers
+// registered by OnConfigChange will be called if any entry changed. It's
+// called when receiving SIGHUP, and the current configure will be kept if
+// any error happens.
+func (s *Server) ReloadConfig() error {
+	if !s.prepared() {
+		return errors.New("configure not loaded, Prepare should be called first")
+	}
+	if len(s.confPath) == 0 {
+		return errors.New("no configure file to reload")
+	}
+
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	conf, err := s.readConf()
+	if err != nil {
+		log.Printf("pid=%d: reload %s error: %s", os.Getpid(), s.confPath, err)
+		return err
+	}
+
+	s.confMutex.Lock()
+	old := s.conf
+	s.conf = conf
+	s.applyConf(conf)
+	s.confMutex.Unlock()
+
+	validateConf(conf)
+
+	changed := old.Diff(conf)
+	if len(changed) == 0 {
+		log.Printf("pid=%d: reload %s ok, nothing changed", os.Getpid(), s.confPath)
+		return nil
+	}
+
+	log.Printf("pid=%d: reload %s ok, changed: %s", os.Getpid(), s.confPath,
+		strings.Join(changed, ", "))
+
+	for _, key := range restartKeys {
+		if old.GetString(key) != conf.GetString(key) {
+			log.Printf("pid=%d: %s changed, restart needed", os.Getpid(), key)
+		}
+	}
+
+	for _, handler := range s.changeHandlers {
+		handler(old, conf)
+	}
+	return nil
+}
+
+// OnConfigChange registers the handler which will be called when the
+// configure has been reloaded and some entries changed.
+func OnConfigChange(handler ConfigChangeFunc) {
+	defaultServer.OnConfigChange(handler)
+}
+
+// CurrentConfig returns the current configure, which should be used instead
+// of AppConf when the configure may be reloaded by ReloadConfig.
+func CurrentConfig() *Config {
+	return defaultServer.Conf()
+}
+
+// ReloadConfig reloads the configure of the default Server, see
+// Server.ReloadConfig.
+func ReloadConfig() error {
+	return defaultServer.ReloadConfig()
+}
+
+// Diff returns the sorted names of the entries which are added, removed or
+// changed in other compared with c.
+func (c *Config) Diff(other *Config) []string {
+	var changed []string
+	for key, value := range c.Entries {
+		if val, found := other.Entries[key]; !found || val != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range other.Entries {
+		if _, found := c.Entries[key]; !found {
+			changed = append(changed, key)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,56 @@
+package master
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reload.cf")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("service reload {\n\tapp_wait_limit = 5\n\ttest_src = hello\n}\n")
+
+	s := New(WithConfigFile(path))
+	if err := s.ReloadConfig(); err == nil {
+		t.Fatalf("ReloadConfig before Prepare ok, Expect error")
+	}
+	if err := s.PrepareE(); err != nil {
+		t.Fatalf("PrepareE error: %s", err)
+	}
+	if s.getSettings().waitLimit != 5 {
+		t.Fatalf("Got: %d, Expect: 5", s.getSettings().waitLimit)
+	}
+
+	var changed []string
+	s.OnConfigChange(func(old, new *Config) {
+		changed = old.Diff(new)
+	})
+
+	write("service reload {\n\tapp_wait_limit = 20\n\ttest_bool = yes\n}\n")
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatalf("ReloadConfig error: %s", err)
+	}
+
+	expect := []string{"app_wait_limit", "test_bool", "test_src"}
+	if !reflect.DeepEqual(changed, expect) {
+		t.Fatalf("Got: %v, Expect: %v", changed, expect)
+	}
+	if s.getSettings().waitLimit != 20 || !s.Conf().GetBool("test_bool") {
+		t.Fatalf("Configure not applied, waitLimit=%d", s.getSettings().waitLimit)
+	}
+
+	write("service reload {\n")
+	if err := s.ReloadConfig(); err == nil {
+		t.Fatalf("ReloadConfig with invalid file ok, Expect error")
+	}
+	if s.getSettings().waitLimit != 20 {
+		t.Fatalf("Got: %d, Expect the old value 20", s.getSettings().waitLimit)
+	}
+}
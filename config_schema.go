@@ -0,0 +1,303 @@
+package master
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// KeyType is the type of the value of one configure entry.
+type KeyType int
+
+const (
+	TypeString KeyType = iota
+	TypeInt
+	TypeBool
+	TypeFloat
+	TypeDuration // such as "30s", or in seconds without unit
+	TypeSize     // such as "512M"
+	TypeList     // such as "a, b; c"
+	TypeMap      // such as "k1:v1, k2:v2"
+)
+
+func (t KeyType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeInt:
+		return "int"
+	case TypeBool:
+		return "bool"
+	case TypeFloat:
+		return "float"
+	case TypeDuration:
+		return "duration"
+	case TypeSize:
+		return "size"
+	case TypeList:
+		return "list"
+	case TypeMap:
+		return "map"
+	default:
+		return fmt.Sprintf("KeyType(%d)", int(t))
+	}
+}
+
+// check checks if the value can be parsed as the type.
+func (t KeyType) check(value string) error {
+	var err error
+	switch t {
+	case TypeInt:
+		if _, e := strconv.Atoi(value); e != nil {
+			err = errors.New("invalid integer")
+		}
+	case TypeBool:
+		_, err = parseBool(value)
+	case TypeFloat:
+		if _, e := strconv.ParseFloat(value, 64); e != nil {
+			err = errors.New("invalid float")
+		}
+	case TypeDuration:
+		_, err = parseDuration(value)
+	case TypeSize:
+		_, err = parseSize(value)
+	}
+	return err
+}
+
+// KeySpec describes one configure entry, which is used to validate the
+// configure and to write the configure template.
+type KeySpec struct {
+	Name        string
+	Type        KeyType
+	Default     string // the value used if the entry isn't set
+	Description string
+	// Validate checks the value after the type has been checked, nil for
+	// any value of the type.
+	Validate func(value string) error
+
+	framework bool // registered by go-service itself
+}
+
+var (
+	schemaMutex sync.RWMutex
+	schemaKeys  []*KeySpec
+	schemaIndex = make(map[string]*KeySpec)
+)
+
+// RegisterKey registers the configure entry used by the application, so it
+// can be validated when loading the configure and be written by
+// WriteTemplate. The entry registered before with the same name will be
+// replaced.
+func RegisterKey(spec KeySpec) {
+	spec.framework = false
+	registerKey(spec)
+}
+
+func registerKey(spec KeySpec) {
+	if len(spec.Name) == 0 {
+		panic("register configure key with empty name")
+	}
+
+	schemaMutex.Lock()
+	defer schemaMutex.Unlock()
+
+	if old, found := schemaIndex[spec.Name]; found {
+		*old = spec
+		return
+	}
+
+	s := &spec
+	schemaKeys = append(schemaKeys, s)
+	schemaIndex[s.Name] = s
+}
+
+// RegisteredKeys returns all the registered entries in registering order.
+func RegisteredKeys() []KeySpec {
+	schemaMutex.RLock()
+	defer schemaMutex.RUnlock()
+
+	specs := make([]KeySpec, 0, len(schemaKeys))
+	for _, s := range schemaKeys {
+		specs = append(specs, *s)
+	}
+	return specs
+}
+
+// Validate checks the entries with the registered keys, the entries whose
+// values are invalid and the unknown entries are reported. The entries
+// beginning with "master_" are known ones because they're used by
+// acl_master.
+func (c *Config) Validate() []error {
+	schemaMutex.RLock()
+	defer schemaMutex.RUnlock()
+
+	names := make([]string, 0, len(c.Entries))
+	for name := range c.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		value := c.Redacted(name)
+		spec, found := schemaIndex[name]
+		if !found {
+			if !strings.HasPrefix(name, "master_") {
+				errs = append(errs, &KeyError{Key: name, Value: value, Reason: "unknown key"})
+			}
+			continue
+		}
+
+		err := spec.Type.check(c.Entries[name])
+		if err == nil && spec.Validate != nil {
+			err = spec.Validate(c.Entries[name])
+		}
+		if err != nil {
+			errs = append(errs, &KeyError{Key: name, Value: value, Reason: err.Error()})
+		}
+	}
+	return errs
+}
+
+// validateConf logs the problems of the configure as warnings.
+func validateConf(conf *Config) {
+	for _, err := range conf.Validate() {
+		log.Printf("pid=%d: configure warning: %s", os.Getpid(), err)
+	}
+}
+
+// WriteTemplate writes the configure template with all the registered
+// entries and their descriptions, just like the configure files of the
+// examples. The entries without default value are commented out.
+func WriteTemplate(w io.Writer) error {
+	specs := RegisteredKeys()
+	bw := bufio.NewWriter(w)
+
+	name := ServiceName
+	if len(name) == 0 {
+		name = filepath.Base(os.Args[0])
+	}
+	fmt.Fprintf(bw, "service %s {\n", name)
+
+	writeSpecs := func(framework bool) {
+		for _, s := range specs {
+			if s.framework != framework {
+				continue
+			}
+
+			for _, line := range strings.Split(s.Description, "\n") {
+				fmt.Fprintf(bw, "#\t%s\n", line)
+			}
+			if len(s.Default) > 0 {
+				fmt.Fprintf(bw, "\t%s = %s\n", s.Name, s.Default)
+			} else {
+				fmt.Fprintf(bw, "#\t%s =\n", s.Name)
+			}
+		}
+	}
+
+	writeSpecs(true)
+	fmt.Fprintf(bw, "\n%s\n", strings.Repeat("#", 76))
+	fmt.Fprintf(bw, "#\tThe application's own configure entries\n\n")
+	writeSpecs(false)
+	fmt.Fprintf(bw, "}\n")
+
+	return bw.Flush()
+}
+
+func nonNegative(value string) error {
+	if n, _ := strconv.Atoi(value); n < 0 {
+		return errors.New("should not be negative")
+	}
+	return nil
+}
+
+func octalMode(value string) error {
+	if _, err := strconv.ParseUint(value, 8, 32); err != nil {
+		return errors.New("invalid octal file mode")
+	}
+	return nil
+}
+
+func init() {
+	specs := []KeySpec{
+		{Name: "master_disable", Type: TypeBool, Default: "no",
+			Description: "Whether the service is disabled"},
+		{Name: "master_service", Type: TypeList,
+			Description: "The addresses of the service, such as: 127.0.0.1|8080, go-service.sock"},
+		{Name: "master_type", Type: TypeString, Default: "sock",
+			Description: "The type of the service"},
+		{Name: "master_reuseport", Type: TypeBool, Default: "no",
+			Description: "Whether to use SO_REUSEPORT if the system supports it"},
+		{Name: "master_private", Type: TypeBool, Default: "n",
+			Description: "Whether the unix domain socket is private"},
+		{Name: "master_unpriv", Type: TypeBool, Default: "n",
+			Description: "Whether to run with the unprivileged user"},
+		{Name: "master_chroot", Type: TypeBool, Default: "n",
+			Description: "Whether to chroot: n -- no, y -- yes"},
+		{Name: "master_wakeup", Type: TypeString, Default: "-",
+			Description: "The interval in seconds to be triggered (only for trigger mode)"},
+		{Name: "master_maxproc", Type: TypeInt, Default: "1", Validate: nonNegative,
+			Description: "The max count of the processes"},
+		{Name: "master_status_notify", Type: TypeBool, Default: "no",
+			Description: "Whether the process reports the busy or idle status to acl_master, so master_maxproc works like the C services"},
+		{Name: "master_prefork", Type: TypeInt, Default: "1", Validate: nonNegative,
+			Description: "The count of the processes started first, should not be greater than master_maxproc"},
+		{Name: "master_command", Type: TypeString,
+			Description: "The path of the program"},
+		{Name: "master_log", Type: TypeString,
+			Description: "The path of the log file"},
+		{Name: "master_stdout", Type: TypeString,
+			Description: "The file the stdout of the program is redirected to"},
+		{Name: "master_stderr", Type: TypeString,
+			Description: "The file the stderr of the program is redirected to"},
+		{Name: "master_owner", Type: TypeString,
+			Description: "The user the process runs as"},
+		{Name: "master_args", Type: TypeString,
+			Description: "The args of the program"},
+		{Name: "master_env", Type: TypeMap,
+			Description: "The environment of the process, which can be got by master.Env()"},
+		{Name: "app_use_limit", Type: TypeInt, Default: "0", Validate: nonNegative,
+			Description: "The process exits after handling so many connections, 0 for no limit"},
+		{Name: "app_idle_limit", Type: TypeInt, Default: "0", Validate: nonNegative,
+			Description: "The process exits after being idle for so many seconds, 0 for no limit"},
+		{Name: "app_busy_limit", Type: TypeInt, Default: "0", Validate: nonNegative,
+			Description: "The process reports itself busy to acl_master when handling so many connections, 0 for being busy only when it's going to exit"},
+		{Name: "app_queue_dir", Type: TypeString,
+			Description: "The path the process runs in"},
+		{Name: "app_threads", Type: TypeInt, Default: "0", Validate: nonNegative,
+			Description: "The count of the threads used if it's greater than 0"},
+		{Name: "app_access_allow", Type: TypeList, Default: "all", Validate: checkAccess,
+			Description: "The ranges of the client IPs allowed, such as: 127.0.0.1:255.255.255.255, 127.0.0.1:127.0.0.1, 10.0.0.0/8"},
+		{Name: "app_quick_abort", Type: TypeBool, Default: "0",
+			Description: "Whether to exit without waiting for the connections when acl_master exits"},
+		{Name: "app_wait_limit", Type: TypeInt, Default: "0", Validate: nonNegative,
+			Description: "The max seconds waiting for the connections when app_quick_abort is 0, 0 for no limit"},
+		{Name: "app_force_quit", Type: TypeBool, Default: "yes",
+			Description: "Whether to exit without waiting for the connections when SIGTERM or SIGINT is received again in alone mode"},
+		{Name: "app_unix_mode", Type: TypeString, Validate: octalMode,
+			Description: "The mode of the unix domain socket files in alone mode, such as 0660"},
+		{Name: "app_unix_owner", Type: TypeString,
+			Description: "The owner of the unix domain socket files in alone mode, such as www:www"},
+		{Name: "app_secrets_dir", Type: TypeString, Default: defaultSecretsDir,
+			Description: "The directory of the secrets referred by @secret:name"},
+		{Name: "tls_cert_file", Type: TypeString,
+			Description: "The certificate file for the TLS of the web service"},
+		{Name: "tls_key_file", Type: TypeString,
+			Description: "The key file for the TLS of the web service"},
+	}
+
+	for _, spec := range specs {
+		spec.framework = true
+		registerKey(spec)
+	}
+}
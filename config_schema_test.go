@@ -0,0 +1,52 @@
+package master
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	RegisterKey(KeySpec{Name: "client_idle_limit", Type: TypeDuration, Default: "60",
+		Description: "The idle time of each client connection"})
+	RegisterKey(KeySpec{Name: "debug_mem", Type: TypeBool, Default: "0",
+		Description: "Whether to output the memory status"})
+
+	myConf := new(Config)
+	myConf.InitConfig("testdata/test.cf")
+	myConf.Entries["app_wait_limit"] = "-1"
+	myConf.Entries["debug_mem"] = "maybe"
+	myConf.Entries["app_force_quit"] = "no"
+
+	var bad []string
+	for _, err := range myConf.Validate() {
+		ke := err.(*KeyError)
+		if ke.Reason != "unknown key" {
+			bad = append(bad, ke.Key)
+		} else if !strings.HasPrefix(ke.Key, "aio_") {
+			t.Fatalf("Got: %s, Expect only aio_ keys unknown", ke)
+		}
+	}
+	if strings.Join(bad, ",") != "app_wait_limit,debug_mem" {
+		t.Fatalf("Got: %v, Expect: [app_wait_limit debug_mem]", bad)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTemplate(&buf); err != nil {
+		t.Fatalf("WriteTemplate error: %s", err)
+	}
+
+	tmpl := &Config{Entries: make(map[string]string)}
+	if err := tmpl.parseConf(&buf, "template.cf"); err != nil {
+		t.Fatalf("Parse template error: %s", err)
+	}
+	if len(tmpl.Sections()) != 1 {
+		t.Fatalf("Got: %v, Expect one service", tmpl.Sections())
+	}
+	if tmpl.GetString("client_idle_limit") != "60" || tmpl.GetString("app_access_allow") != "all" {
+		t.Fatalf("Unexpected template entries: %v", tmpl.Entries)
+	}
+	if errs := tmpl.Validate(); len(errs) > 0 {
+		t.Fatalf("Template invalid: %v", errs)
+	}
+}
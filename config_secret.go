@@ -0,0 +1,98 @@
+package master
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	secretFilePrefix = "@file:"   // @file:/run/secrets/db_pass
+	secretEnvPrefix  = "@env:"    // @env:DB_PASS
+	secretDirPrefix  = "@secret:" // @secret:db_pass in app_secrets_dir
+
+	defaultSecretsDir = "/run/secrets"
+	redacted          = "******"
+)
+
+// resolveSecrets replaces the values referring to the secrets with the
+// secrets' contents, and marks them as secret so they'll be redacted from
+// the logs and the dumps. The value may be "@file:path" for the content of
+// the file, "@env:NAME" for the environment variable, or "@secret:name"
+// for the file in the directory given by app_secrets_dir.
+func (c *Config) resolveSecrets() error {
+	dir := c.GetStringDefault("app_secrets_dir", defaultSecretsDir)
+
+	for key, value := range c.Entries {
+		var (
+			secret string
+			err    error
+		)
+
+		switch {
+		case strings.HasPrefix(value, secretFilePrefix):
+			secret, err = readSecret(value[len(secretFilePrefix):])
+		case strings.HasPrefix(value, secretDirPrefix):
+			secret, err = readSecret(filepath.Join(dir, value[len(secretDirPrefix):]))
+		case strings.HasPrefix(value, secretEnvPrefix):
+			name := value[len(secretEnvPrefix):]
+			var found bool
+			if secret, found = os.LookupEnv(name); !found {
+				err = fmt.Errorf("environment %s not set", name)
+			}
+		default:
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("resolve secret %s: %s", key, err)
+		}
+
+		c.Entries[key] = secret
+		if c.secrets == nil {
+			c.secrets = make(map[string]bool)
+		}
+		c.secrets[key] = true
+	}
+	return nil
+}
+
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// IsSecret checks if the value of the entry is a secret.
+func (c *Config) IsSecret(name string) bool {
+	return c.secrets[name]
+}
+
+// Redacted returns the value of the entry for logging, the secret will be
+// replaced with "******".
+func (c *Config) Redacted(name string) string {
+	if c.IsSecret(name) {
+		return redacted
+	}
+	return c.GetString(name)
+}
+
+// String dumps all the entries sorted by names as "key = value" lines,
+// with the secrets redacted.
+func (c *Config) String() string {
+	names := make([]string, 0, len(c.Entries))
+	for name := range c.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s = %s\n", name, c.Redacted(name))
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package master
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "db_pass"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("GO_SERVICE_TEST_TOKEN", "t0ken")
+	defer os.Unsetenv("GO_SERVICE_TEST_TOKEN")
+
+	conf, err := New(WithConfigFile("testdata/test.cf"), WithOverrides(
+		"app_secrets_dir="+dir,
+		"db_pass=@secret:db_pass",
+		"tls_key_pass=@file:"+filepath.Join(dir, "db_pass"),
+		"master_args=@env:GO_SERVICE_TEST_TOKEN",
+	)).readConf()
+	if err != nil {
+		t.Fatalf("readConf error: %s", err)
+	}
+
+	for key, value := range map[string]string{
+		"db_pass":      "s3cret",
+		"tls_key_pass": "s3cret",
+		"master_args":  "t0ken",
+	} {
+		if conf.GetString(key) != value || !conf.IsSecret(key) || conf.Redacted(key) != "******" {
+			t.Fatalf("Got: %s = %s, Expect secret %s", key, conf.GetString(key), value)
+		}
+	}
+	if conf.IsSecret("master_log") {
+		t.Fatalf("master_log shouldn't be secret")
+	}
+
+	dump := conf.String()
+	if strings.Contains(dump, "s3cret") || strings.Contains(dump, "t0ken") ||
+		!strings.Contains(dump, "db_pass = ******\n") {
+		t.Fatalf("Secrets not redacted in dump:\n%s", dump)
+	}
+
+	s := New(WithConfigFile("testdata/test.cf"),
+		WithOverrides("db_pass=@file:"+filepath.Join(dir, "none")))
+	if _, err := s.readConf(); err == nil {
+		t.Fatalf("readConf with missing secret ok, Expect error")
+	}
+}
@@ -1,6 +1,12 @@
 package master
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestConfig(t *testing.T) {
 	confFile := "testdata/test.cf"
@@ -15,3 +21,244 @@ func TestConfig(t *testing.T) {
 		)
 	}
 }
+
+type redisConf struct {
+	Addr    string        `cf:"addr,required"`
+	Timeout time.Duration `cf:"timeout,default=3s"`
+}
+
+type appConf struct {
+	IdleLimit int       `cf:"client_idle_limit,default=60"`
+	DebugMem  bool      `cf:"debug_mem"`
+	Backends  []string  `cf:"backend_addrs"`
+	Ports     []int     `cf:"ports"`
+	Redis     redisConf `cf:"redis"`
+	PoolSize  int       `cf:"redis_pool_size"`
+	Ignored   string
+}
+
+func TestUnmarshal(t *testing.T) {
+	myConf := &Config{Entries: map[string]string{
+		"debug_mem":       "yes",
+		"backend_addrs":   "127.0.0.1:8080, 127.0.0.1:8081; 127.0.0.1:8082",
+		"ports":           "80, 443",
+		"redis_addr":      "127.0.0.1:6379",
+		"redis_timeout":   "10",
+		"redis_pool_size": "8",
+	}}
+
+	var ac appConf
+	if err := myConf.Unmarshal(&ac); err != nil {
+		t.Fatalf("Unmarshal error: %s", err)
+	}
+
+	if ac.IdleLimit != 60 || !ac.DebugMem || len(ac.Backends) != 3 ||
+		len(ac.Ports) != 2 || ac.Ports[1] != 443 {
+		t.Fatalf("Unexpected result: %+v", ac)
+	}
+	if ac.Redis.Addr != "127.0.0.1:6379" || ac.Redis.Timeout != 10*time.Second ||
+		ac.PoolSize != 8 {
+		t.Fatalf("Unexpected nested result: %+v", ac.Redis)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	myConf := &Config{Entries: map[string]string{
+		"client_idle_limit": "sixty",
+		"redis_tiemout":     "3s",
+	}}
+
+	var ac appConf
+	err := myConf.Unmarshal(&ac)
+	ue, ok := err.(*UnmarshalError)
+	if !ok {
+		t.Fatalf("Got: %v, Expect: *UnmarshalError", err)
+	}
+
+	expect := []string{"client_idle_limit", "redis_addr", "redis_tiemout"}
+	if len(ue.Errors) != len(expect) {
+		t.Fatalf("Got: %s, Expect keys: %v", ue, expect)
+	}
+	for i, key := range expect {
+		if ue.Errors[i].Key != key {
+			t.Fatalf("Got: %s, Expect: %s", ue.Errors[i].Key, key)
+		}
+	}
+}
+
+func TestConfigSections(t *testing.T) {
+	myConf := new(Config)
+	myConf.InitConfig("testdata/multi.cf")
+
+	sections := myConf.Sections()
+	if len(sections) != 2 || sections[0] != "echo" || sections[1] != "httpd" {
+		t.Fatalf("Got: %v, Expect: [echo httpd]", sections)
+	}
+
+	echo := myConf.Section("echo")
+	if echo.GetInt("app_wait_limit") != 5 || echo.GetInt("client_idle_limit") != 60 {
+		t.Fatalf("Unexpected echo entries: %v", echo.Entries)
+	}
+	if echo.GetString("master_log") != "/opt/soft/acl-master/var/log/multi.log" {
+		t.Fatalf("Got: %s, Expect the global master_log", echo.GetString("master_log"))
+	}
+
+	httpd := myConf.Section("httpd")
+	if _, found := httpd.Entries["client_idle_limit"]; found ||
+		httpd.GetInt("app_wait_limit") != 10 {
+		t.Fatalf("Unexpected httpd entries: %v", httpd.Entries)
+	}
+	if myConf.Section("none") != nil {
+		t.Fatalf("Got section for none, Expect nil")
+	}
+
+	if myConf.serviceConf("httpd") != httpd {
+		t.Fatalf("serviceConf by block name failed")
+	}
+	if myConf.serviceConf("127.0.0.1|5200,echo.sock") != echo {
+		t.Fatalf("serviceConf by master_service failed")
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	if _, err := LoadConfig("testdata/none.cf"); !os.IsNotExist(err) {
+		t.Fatalf("Got: %v, Expect: not exist error", err)
+	}
+
+	tests := []struct {
+		content string
+		line    int
+	}{
+		{"service a {\n\tkey = value\n", 1},
+		{"key = value\n}\n", 2},
+		{"service a {\nservice b {\n}\n}\n", 2},
+		{"service a {\n}\nservice a {\n}\n", 3},
+	}
+
+	for _, test := range tests {
+		c := &Config{Entries: make(map[string]string)}
+		err := c.parseConf(strings.NewReader(test.content), "test.cf")
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("Got: %v, Expect: *ParseError for %q", err, test.content)
+		}
+		if pe.File != "test.cf" || pe.Line != test.line {
+			t.Fatalf("Got: %s, Expect line %d for %q", pe, test.line, test.content)
+		}
+	}
+}
+
+func TestLoadConfigInvalidLine(t *testing.T) {
+	c := &Config{Entries: make(map[string]string)}
+	content := "key1 = value1\nkey value\n= value\nkey2 = value2\n"
+	if err := c.parseConf(strings.NewReader(content), "test.cf"); err != nil {
+		t.Fatalf("parseConf error: %s", err)
+	}
+	if len(c.Entries) != 2 || c.Entries["key1"] != "value1" || c.Entries["key2"] != "value2" {
+		t.Fatalf("Got: %v, Expect: key1 and key2", c.Entries)
+	}
+}
+
+func TestConfigInclude(t *testing.T) {
+	myConf, err := LoadConfig("testdata/include/main.cf")
+	if err != nil {
+		t.Fatalf("LoadConfig error: %s", err)
+	}
+
+	conf := myConf.Section("include")
+	expect := map[string]string{
+		"master_service": "127.0.0.1|8881",
+		"master_log":     "/opt/soft/acl-master/var/log/include.log",
+		"master_env":     "logme:FALSE, priority:E_LOG_INFO,sync_action:E_LOG_SEM",
+		"app_queue_dir":  "/opt/soft/include/var",
+		"master_command": "{install_path}/sbin/include",
+		"test_src":       "hello",
+		"test_bool":      "true",
+	}
+	for key, value := range expect {
+		if conf.GetString(key) != value {
+			t.Fatalf("Got: %s = %s, Expect: %s", key, conf.GetString(key), value)
+		}
+	}
+
+	_, err = LoadConfig("testdata/include/cycle_a.cf")
+	if pe, ok := err.(*ParseError); !ok || !strings.Contains(pe.Msg, "include cycle") {
+		t.Fatalf("Got: %v, Expect: include cycle error", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "missing.cf")
+	if err := os.WriteFile(path, []byte("key = value\ninclude = none.cf\n"), 0644); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+	_, err = LoadConfig(path)
+	if pe, ok := err.(*ParseError); !ok || pe.File != path || pe.Line != 2 {
+		t.Fatalf("Got: %v, Expect: %s:2: include error", err, path)
+	}
+}
+
+func TestConfigGetters(t *testing.T) {
+	myConf := new(Config)
+	myConf.InitConfig("testdata/test.cf")
+
+	if !myConf.Has("master_log") || myConf.Has("master_notify_addr") {
+		t.Fatalf("Has error")
+	}
+
+	env := myConf.GetMap("master_env")
+	if len(env) != 2 || env["mempool_limit"] != "512000000" || env["mempool_use_mutex"] != "true" {
+		t.Fatalf("Got: %v, Expect the map of master_env", env)
+	}
+
+	allows := myConf.GetStrings("aio_access_allow", ",")
+	if len(allows) != 2 || allows[1] != "127.0.0.1:127.0.0.1" {
+		t.Fatalf("Got: %v, Expect two ranges", allows)
+	}
+
+	if d := myConf.GetDuration("aio_rw_timeout"); d != 120*time.Second {
+		t.Fatalf("Got: %s, Expect: 2m0s", d)
+	}
+	if n := myConf.GetIntDefault("master_notify_addr", 5801); n != 5801 {
+		t.Fatalf("Got: %d, Expect: 5801", n)
+	}
+	if !myConf.GetBoolDefault("master_notify", true) || myConf.GetBoolDefault("master_unpriv", true) {
+		t.Fatalf("GetBoolDefault error")
+	}
+	for _, value := range []string{"on", "TRUE", "Yes", "1"} {
+		myConf.Entries["debug_mem"] = value
+		if !myConf.GetBool("debug_mem") {
+			t.Fatalf("GetBool Got: false, Expect: true for %s", value)
+		}
+	}
+
+	sizes := map[string]int64{
+		"8192":  8192,
+		"512K":  512 << 10,
+		"512MB": 512 << 20,
+		"1.5g":  3 << 29,
+	}
+	for value, expect := range sizes {
+		myConf.Entries["buf_size"] = value
+		if n := myConf.GetSize("buf_size"); n != expect {
+			t.Fatalf("Got: %d, Expect: %d for %s", n, expect, value)
+		}
+	}
+
+	for _, value := range []string{"huge", "-5K", "10000000T", "1e30"} {
+		myConf.Entries["buf_size"] = value
+		if n := myConf.GetSizeDefault("buf_size", 4096); n != 4096 {
+			t.Fatalf("Got: %d, Expect the default 4096 for %s", n, value)
+		}
+	}
+
+	if list := myConf.GetStringsDefault("none_list", "", []string{"a"}); len(list) != 1 || list[0] != "a" {
+		t.Fatalf("Got: %v, Expect the default [a]", list)
+	}
+	if m := myConf.GetMapDefault("none_map", map[string]string{"k": "v"}); m["k"] != "v" {
+		t.Fatalf("Got: %v, Expect the default map[k:v]", m)
+	}
+
+	myConf.Entries["ratio"] = "0.75"
+	if f := myConf.GetFloat("ratio"); f != 0.75 {
+		t.Fatalf("Got: %f, Expect: 0.75", f)
+	}
+}
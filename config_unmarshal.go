@@ -0,0 +1,288 @@
+package master
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyError describes one configure entry which can't be bound to the
+// struct field in Unmarshal.
+type KeyError struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+func (e *KeyError) Error() string {
+	if len(e.Value) > 0 {
+		return fmt.Sprintf("%s = %s: %s", e.Key, e.Value, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s", e.Key, e.Reason)
+}
+
+// UnmarshalError holds all the problems found by Unmarshal, so the
+// application can report all of them at once.
+type UnmarshalError struct {
+	Errors []*KeyError
+}
+
+func (e *UnmarshalError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return "unmarshal configure: " + strings.Join(msgs, "; ")
+}
+
+const tagName = "cf"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type fieldTag struct {
+	name     string
+	def      string
+	hasDef   bool
+	required bool
+}
+
+func parseFieldTag(tag string) (*fieldTag, error) {
+	opts := strings.Split(tag, ",")
+	ft := &fieldTag{name: strings.TrimSpace(opts[0])}
+	for _, opt := range opts[1:] {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case opt == "required":
+			ft.required = true
+		case strings.HasPrefix(opt, "default="):
+			ft.def = opt[len("default="):]
+			ft.hasDef = true
+		default:
+			return nil, fmt.Errorf("unknown option %q in tag `%s:\"%s\"`",
+				opt, tagName, tag)
+		}
+	}
+	return ft, nil
+}
+
+// Unmarshal binds the entries of the configure to the struct pointed by v,
+// the fields are bound by the "cf" tag, such as:
+//
+//	type AppConfig struct {
+//		IdleLimit int           `cf:"client_idle_limit,default=60"`
+//		DebugMem  bool          `cf:"debug_mem"`
+//		Timeout   time.Duration `cf:"rw_timeout,default=30s"`
+//		Backends  []string      `cf:"backend_addrs,required"`
+//		Redis     RedisConfig   `cf:"redis"`
+//	}
+//
+// The fields of a nested struct are bound with the entries named with the
+// nested tag name as prefix, e.g. "redis_addr" for the field tagged "addr"
+// in RedisConfig, and the entries with that prefix but bound to no field
+// are reported as unknown. Durations without unit are in seconds, the
+// items of slices are separated by ',' or ';', and map[string]string is
+// bound with the value like "k1:v1, k2:v2". Fields without "cf" tag or
+// tagged with "-" are skipped. Entries which are missing but required, or
+// which can't be converted to the field's type are reported with an
+// *UnmarshalError.
+func (c *Config) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("unmarshal configure: v must be a non-nil pointer to struct")
+	}
+
+	u := &unmarshaler{conf: c}
+	bound, err := u.bindFields(rv.Elem(), "")
+	if err != nil {
+		return err
+	}
+
+	// The unknown entries are checked after all the fields are bound, so
+	// the field such as "redis_pool_size" beside the nested struct tagged
+	// "redis" isn't reported.
+	u.checkUnknown(bound)
+
+	if len(u.errs) > 0 {
+		return &UnmarshalError{Errors: u.errs}
+	}
+	return nil
+}
+
+type unmarshaler struct {
+	conf     *Config
+	errs     []*KeyError
+	prefixes []string // the prefixes of the nested structs
+}
+
+func (u *unmarshaler) addError(key, value, reason string) {
+	u.errs = append(u.errs, &KeyError{Key: key, Value: value, Reason: reason})
+}
+
+// bindFields binds the fields of the struct rv with the entries named with
+// the prefix, and returns the names of the entries been bound.
+func (u *unmarshaler) bindFields(rv reflect.Value, prefix string) (map[string]bool, error) {
+	bound := make(map[string]bool)
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, ok := sf.Tag.Lookup(tagName)
+		if !ok || tag == "-" {
+			continue
+		}
+		if len(sf.PkgPath) > 0 {
+			return nil, fmt.Errorf("unmarshal configure: field %s.%s is unexported",
+				rt.Name(), sf.Name)
+		}
+
+		ft, err := parseFieldTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal configure: field %s.%s: %s",
+				rt.Name(), sf.Name, err)
+		}
+		if len(ft.name) == 0 {
+			return nil, fmt.Errorf("unmarshal configure: field %s.%s has no name in tag",
+				rt.Name(), sf.Name)
+		}
+
+		fv := rv.Field(i)
+		name := prefix + ft.name
+
+		if isNestedStruct(sf.Type) {
+			if sf.Type.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					fv.Set(reflect.New(sf.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+
+			nested, err := u.bindFields(fv, name+"_")
+			if err != nil {
+				return nil, err
+			}
+			u.prefixes = append(u.prefixes, name+"_")
+			for key := range nested {
+				bound[key] = true
+			}
+			continue
+		}
+
+		bound[name] = true
+
+		value, found := u.conf.Entries[name]
+		if !found {
+			if ft.required {
+				u.addError(name, "", "required but missing")
+				continue
+			}
+			if !ft.hasDef {
+				continue
+			}
+			value = ft.def
+		}
+
+		if err := setField(fv, value); err != nil {
+			if u.conf.IsSecret(name) {
+				value = redacted
+			}
+			u.addError(name, value, err.Error())
+		}
+	}
+
+	return bound, nil
+}
+
+// checkUnknown reports the entries with the prefixes of the nested structs
+// which haven't been bound to any field.
+func (u *unmarshaler) checkUnknown(bound map[string]bool) {
+	var unknown []string
+	for key := range u.conf.Entries {
+		if bound[key] {
+			continue
+		}
+		for _, prefix := range u.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				unknown = append(unknown, key)
+				break
+			}
+		}
+	}
+
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		u.addError(key, u.conf.Entries[key], "unknown key")
+	}
+}
+
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func setField(fv reflect.Value, value string) error {
+	if fv.Type() == durationType {
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("invalid integer")
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("invalid unsigned integer")
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return errors.New("invalid float")
+		}
+		fv.SetFloat(f)
+	case reflect.Map:
+		if fv.Type().Key().Kind() != reflect.String || fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", fv.Type())
+		}
+		m := reflect.MakeMap(fv.Type())
+		for k, v := range parseMap(value) {
+			m.SetMapIndex(reflect.ValueOf(k).Convert(fv.Type().Key()),
+				reflect.ValueOf(v).Convert(fv.Type().Elem()))
+		}
+		fv.Set(m)
+	case reflect.Slice:
+		items := splitList(value)
+		slice := reflect.MakeSlice(fv.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setField(slice.Index(i), item); err != nil {
+				return fmt.Errorf("item %d: %s", i, err)
+			}
+		}
+		fv.Set(slice)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package master
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseBool parses the bool value like GetBool, but it also recognizes the
+// negative words and reports the values which can't be recognized.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "true", "y", "on":
+		return true, nil
+	case "no", "false", "n", "off":
+		return false, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return false, errors.New("invalid bool")
+	}
+	return n != 0, nil
+}
+
+// parseDuration parses the duration such as "30s" or "1m30s", the value
+// without unit is in seconds just like the limits of acl_master.
+func parseDuration(value string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.New("invalid duration")
+	}
+	return d, nil
+}
+
+// splitList splits the value like "a, b; c" into items, the empty items
+// will be skipped.
+func splitList(value string) []string {
+	return splitBy(value, ",;")
+}
+
+// splitBy splits the value by any char in seps, and trims the blanks of the
+// items, the empty items will be skipped.
+func splitBy(value string, seps string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(seps, r)
+	})
+
+	items := make([]string, 0, len(fields))
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if len(field) > 0 {
+			items = append(items, field)
+		}
+	}
+	return items
+}
+
+// parseMap parses the value like "k1:v1, k2:v2" which is used by master_env,
+// the item without ':' will be mapped to empty value.
+func parseMap(value string) map[string]string {
+	m := make(map[string]string)
+	for _, item := range splitList(value) {
+		pos := strings.IndexByte(item, ':')
+		if pos < 0 {
+			m[item] = ""
+			continue
+		}
+
+		key := strings.TrimSpace(item[:pos])
+		if len(key) > 0 {
+			m[key] = strings.TrimSpace(item[pos+1:])
+		}
+	}
+	return m
+}
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// parseSize parses the size such as "8192", "512K", "512M" or "1.5G", the
+// suffix "B" can be appended to the unit, like "512MB".
+func parseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	if strings.HasSuffix(s, "B") {
+		s = s[:len(s)-1]
+	}
+
+	unit := ""
+	if len(s) > 0 {
+		if _, found := sizeUnits[s[len(s)-1:]]; found {
+			unit = s[len(s)-1:]
+			s = strings.TrimSpace(s[:len(s)-1])
+		}
+	}
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n < 0 || n > math.MaxInt64/sizeUnits[unit] {
+			return 0, errors.New("invalid size")
+		}
+		return n * sizeUnits[unit], nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || f*float64(sizeUnits[unit]) >= math.MaxInt64 {
+		return 0, errors.New("invalid size")
+	}
+	return int64(f * float64(sizeUnits[unit])), nil
+}
@@ -2,4 +2,8 @@ module github.com/acl-dev/go-service
 
 go 1.16
 
-require golang.org/x/sys v0.4.0
+require (
+	github.com/BurntSushi/toml v1.3.2
+	golang.org/x/sys v0.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
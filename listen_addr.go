@@ -0,0 +1,265 @@
+package master
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ListenAddr is one address to listen, which is parsed from the addrs such
+// as master_service or the addrs given in alone mode.
+type ListenAddr struct {
+	Network string // "tcp" or "unix"
+	// Host is the IP, the host name, the interface name such as "eth0", or
+	// empty for any address, or the path of the unix domain socket.
+	Host string
+	Port int
+	// Options are given after '?' such as "app.sock?mode=0660&owner=www",
+	// which are "mode" and "owner" for the unix domain socket, and
+	// "reuseport" for TCP.
+	Options map[string]string
+}
+
+// listenOptions are the options supported by the networks.
+var listenOptions = map[string][]string{
+	"tcp":  {"reuseport"},
+	"unix": {"mode", "owner"},
+}
+
+// ParseListenAddr parses the address to listen, which may be:
+//
+//	5200                    the port only, listening any address
+//	*:5200, :5200           any address
+//	127.0.0.1:5200          IPv4 with ':' or '|'
+//	[::1]:5200, ::1|5200    IPv6 in brackets, or with '|'
+//	eth0:5200               the first address of the interface
+//	unix:/run/app.sock      the unix domain socket, the prefix "unix:" can
+//	                        be omitted if the path has '/' or ends with
+//	                        ".sock", and "@app" is the abstract one
+func ParseListenAddr(addr string) (ListenAddr, error) {
+	a := ListenAddr{}
+	s := strings.TrimSpace(addr)
+	if len(s) == 0 {
+		return a, errors.New("empty address")
+	}
+
+	if pos := strings.IndexByte(s, '?'); pos >= 0 {
+		options, err := parseListenOptions(s[pos+1:])
+		if err != nil {
+			return a, fmt.Errorf("address %q: %s", addr, err)
+		}
+		a.Options = options
+		s = s[:pos]
+	}
+
+	if path, ok := unixSocketPath(s); ok {
+		if len(path) == 0 || path == "@" {
+			return a, fmt.Errorf("address %q: empty unix socket path", addr)
+		}
+		a.Network, a.Host = "unix", path
+		return a, a.checkOptions(addr)
+	}
+
+	a.Network = "tcp"
+	host, port, err := splitHostPort(s)
+	if err != nil {
+		return a, fmt.Errorf("address %q: %s", addr, err)
+	}
+
+	a.Port, err = strconv.Atoi(port)
+	if err != nil || a.Port < 0 || a.Port > 65535 {
+		return a, fmt.Errorf("address %q: invalid port %q", addr, port)
+	}
+
+	if host == "*" {
+		host = ""
+	}
+	if strings.IndexByte(host, ':') >= 0 && net.ParseIP(zoneless(host)) == nil {
+		return a, fmt.Errorf("address %q: invalid IPv6 %q", addr, host)
+	}
+	a.Host = host
+	return a, a.checkOptions(addr)
+}
+
+// splitHostPort splits the host and port, the port is after the last '|'
+// if there's any, or else after the last ':'.
+func splitHostPort(s string) (string, string, error) {
+	if pos := strings.LastIndexByte(s, '|'); pos >= 0 {
+		return strings.Trim(s[:pos], "[]"), s[pos+1:], nil
+	}
+
+	if strings.HasPrefix(s, "[") {
+		host, port, err := net.SplitHostPort(s)
+		if err != nil {
+			return "", "", errors.New("invalid [IPv6]:port")
+		}
+		return host, port, nil
+	}
+
+	switch strings.Count(s, ":") {
+	case 0:
+		// Only the port, such as "5200".
+		return "", s, nil
+	case 1:
+		pos := strings.IndexByte(s, ':')
+		return s[:pos], s[pos+1:], nil
+	default:
+		return "", "", errors.New("ambiguous IPv6, [IPv6]:port or IPv6|port expected")
+	}
+}
+
+func zoneless(host string) string {
+	if pos := strings.IndexByte(host, '%'); pos >= 0 {
+		return host[:pos]
+	}
+	return host
+}
+
+func parseListenOptions(s string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, kv := range strings.Split(s, "&") {
+		if len(kv) == 0 {
+			continue
+		}
+
+		key, value := kv, ""
+		if pos := strings.IndexByte(kv, '='); pos >= 0 {
+			key, value = kv[:pos], kv[pos+1:]
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("invalid option %q", kv)
+		}
+		options[key] = value
+	}
+	return options, nil
+}
+
+// checkOptions checks if the options are supported by the network.
+func (a ListenAddr) checkOptions(addr string) error {
+	for key, value := range a.Options {
+		supported := false
+		for _, name := range listenOptions[a.Network] {
+			if key == name {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("address %q: unknown option %q for %s", addr, key, a.Network)
+		}
+
+		var err error
+		switch key {
+		case "mode":
+			err = octalMode(value)
+		case "reuseport":
+			_, err = parseBool(value)
+		}
+		if err != nil {
+			return fmt.Errorf("address %q: option %s: %s", addr, key, err)
+		}
+	}
+	return nil
+}
+
+// String returns the address in the form of "host:port", or "unix:path".
+func (a ListenAddr) String() string {
+	if a.Network == "unix" {
+		return "unix:" + a.Host
+	}
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// address returns the address for net.Listen, the interface name is
+// replaced with its first address.
+func (a ListenAddr) address() (string, error) {
+	if a.Network == "unix" {
+		return a.Host, nil
+	}
+
+	host := a.Host
+	if len(host) > 0 && net.ParseIP(zoneless(host)) == nil {
+		if ifi, err := net.InterfaceByName(host); err == nil {
+			addrs, err := ifi.Addrs()
+			if err != nil {
+				return "", err
+			}
+			if len(addrs) == 0 {
+				return "", fmt.Errorf("no address of interface %s", host)
+			}
+			ipnet, ok := addrs[0].(*net.IPNet)
+			if !ok {
+				return "", fmt.Errorf("invalid address of interface %s", host)
+			}
+			host = ipnet.IP.String()
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.Port)), nil
+}
+
+// unixSocketPath returns the path of the unix domain socket if the addr
+// is one, such as "unix:/run/app.sock", "/run/app.sock", "app.sock", or
+// "@app" for the abstract socket on linux.
+func unixSocketPath(addr string) (string, bool) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return addr[len("unix:"):], true
+	case strings.HasPrefix(addr, "@"):
+		return addr, true
+	case strings.Contains(addr, "/"), strings.HasSuffix(addr, ".sock"):
+		return addr, true
+	default:
+		return "", false
+	}
+}
+
+// ParseListenAddrs parses the addrs separated by ',' or ';', the blanks in
+// each address are removed such as "127.0.0.1 | 8080". See ParseListenAddr
+// for the format of each address.
+func ParseListenAddrs(addrs string) ([]ListenAddr, error) {
+	tokens := splitList(addrs)
+	if len(tokens) == 0 {
+		return nil, errors.New("no valid addrs for listening")
+	}
+
+	list := make([]ListenAddr, 0, len(tokens))
+	for _, token := range tokens {
+		a, err := ParseListenAddr(strings.Join(strings.Fields(token), ""))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// listenAddrs listens the addrs, which may be the TCP addresses or the
+// unix domain sockets. The invalid addrs are reported as the error, and
+// the addresses which can't be listened are skipped.
+func (s *Server) listenAddrs(addrs string) ([]net.Listener, error) {
+	list, err := ParseListenAddrs(addrs)
+	if err != nil {
+		log.Println("Parse addrs error:", err)
+		return nil, err
+	}
+
+	listeners := []net.Listener(nil)
+	for _, a := range list {
+		ln, err := s.listen(a)
+		if err == nil {
+			listeners = append(listeners, ln)
+			log.Printf("Listen %s ok\r\n", a)
+			continue
+		}
+
+		log.Println("Listen", a, "error:", err)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("no listeners were created")
+	}
+	return listeners, nil
+}
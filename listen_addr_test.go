@@ -0,0 +1,91 @@
+package master
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		expect ListenAddr
+		str    string
+	}{
+		{"5200", ListenAddr{Network: "tcp", Port: 5200}, ":5200"},
+		{"*:5200", ListenAddr{Network: "tcp", Port: 5200}, ":5200"},
+		{":5200", ListenAddr{Network: "tcp", Port: 5200}, ":5200"},
+		{"127.0.0.1:8080", ListenAddr{Network: "tcp", Host: "127.0.0.1", Port: 8080}, "127.0.0.1:8080"},
+		{"127.0.0.1|8080", ListenAddr{Network: "tcp", Host: "127.0.0.1", Port: 8080}, "127.0.0.1:8080"},
+		{"[::1]:8080", ListenAddr{Network: "tcp", Host: "::1", Port: 8080}, "[::1]:8080"},
+		{"::1|8080", ListenAddr{Network: "tcp", Host: "::1", Port: 8080}, "[::1]:8080"},
+		{"[::1]|8080", ListenAddr{Network: "tcp", Host: "::1", Port: 8080}, "[::1]:8080"},
+		{"[fe80::1%eth0]:80", ListenAddr{Network: "tcp", Host: "fe80::1%eth0", Port: 80}, "[fe80::1%eth0]:80"},
+		{"eth0:80", ListenAddr{Network: "tcp", Host: "eth0", Port: 80}, "eth0:80"},
+		{"127.0.0.1:0?reuseport=no", ListenAddr{Network: "tcp", Host: "127.0.0.1",
+			Options: map[string]string{"reuseport": "no"}}, "127.0.0.1:0"},
+		{"unix:/run/a.sock?mode=0660&owner=www", ListenAddr{Network: "unix", Host: "/run/a.sock",
+			Options: map[string]string{"mode": "0660", "owner": "www"}}, "unix:/run/a.sock"},
+		{"a.sock", ListenAddr{Network: "unix", Host: "a.sock"}, "unix:a.sock"},
+		{"@abs", ListenAddr{Network: "unix", Host: "@abs"}, "unix:@abs"},
+	}
+
+	for _, test := range tests {
+		a, err := ParseListenAddr(test.addr)
+		if err != nil {
+			t.Fatalf("ParseListenAddr(%q) error: %s", test.addr, err)
+		}
+		if !reflect.DeepEqual(a, test.expect) {
+			t.Fatalf("ParseListenAddr(%q) Got: %+v, Expect: %+v", test.addr, a, test.expect)
+		}
+		if a.String() != test.str {
+			t.Fatalf("String Got: %s, Expect: %s", a, test.str)
+		}
+	}
+}
+
+func TestParseListenAddrError(t *testing.T) {
+	addrs := []string{
+		"",
+		"::1:8080",
+		"127.0.0.1:70000",
+		"127.0.0.1:-1",
+		"127.0.0.1:",
+		"host:abc",
+		"[::1]8080",
+		"zz::1|8080",
+		"unix:",
+		"x.sock?reuseport=1",
+		"1.2.3.4:80?mode=0660",
+		"x.sock?mode=999",
+		"1.2.3.4:80?reuseport=maybe",
+		"1.2.3.4:80?=1",
+	}
+
+	for _, addr := range addrs {
+		if a, err := ParseListenAddr(addr); err == nil {
+			t.Fatalf("ParseListenAddr(%q) Got: %+v, Expect error", addr, a)
+		}
+	}
+}
+
+func TestParseListenAddrs(t *testing.T) {
+	list, err := ParseListenAddrs("127.0.0.1 | 80, [::1]:80; 8080,\tapp.sock")
+	if err != nil {
+		t.Fatalf("ParseListenAddrs error: %s", err)
+	}
+	if len(list) != 4 {
+		t.Fatalf("Got: %d addrs, Expect: 4", len(list))
+	}
+	if list[0].Host != "127.0.0.1" || list[0].Port != 80 ||
+		list[2].Port != 8080 || list[3].Network != "unix" {
+		t.Fatalf("Got: %+v, Expect: 127.0.0.1:80, 8080 and unix", list)
+	}
+
+	// The invalid address isn't skipped silently.
+	if _, err := ParseListenAddrs("127.0.0.1:80, ::1:80"); err == nil {
+		t.Fatalf("ParseListenAddrs with ::1:80 ok, Expect error")
+	}
+	if _, err := ParseListenAddrs(" ; "); err == nil {
+		t.Fatalf("ParseListenAddrs with none address ok, Expect error")
+	}
+}
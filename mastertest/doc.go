@@ -0,0 +1,11 @@
+// Package mastertest works like acl_master to test the services in daemon
+// mode without installing acl_master. The service is given the connection
+// on the state fd and the listeners on the listen fds, either by spawning
+// the service program with Start, or by running the service in process
+// with the Option of the Master created by New. The tests can disconnect
+// the service from the Master to check how the service drains its
+// connections with app_wait_limit and app_quick_abort, and read the status
+// reported by the service.
+//
+// It works on linux and darwin only.
+package mastertest
@@ -0,0 +1,225 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package mastertest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	master "github.com/acl-dev/go-service"
+)
+
+// The status reported by the service, the same as ACL_MASTER_STAT_TAKEN
+// and ACL_MASTER_STAT_AVAIL of acl.
+const (
+	StatusTaken = 0
+	StatusAvail = 1
+)
+
+// Status is the status reported by the service on the state fd.
+type Status struct {
+	Pid    int
+	Gen    uint32
+	Status int
+}
+
+// Master holds the listeners and the state connection of the service.
+type Master struct {
+	listeners []net.Listener
+	conn      net.Conn // the side of acl_master
+	child     *os.File // the side of the service
+	cmd       *exec.Cmd
+	exited    chan struct{}
+	exitErr   error
+}
+
+// New creates the Master listening the addrs such as "127.0.0.1:0", the
+// service can run in process with the Option of the Master.
+func New(addrs ...string) (*Master, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no addrs to listen")
+	}
+
+	m := &Master{}
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.listeners = append(m.listeners, ln)
+	}
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+
+	file := os.NewFile(uintptr(fds[0]), "master state")
+	m.conn, err = net.FileConn(file)
+	_ = file.Close()
+	if err != nil {
+		_ = syscall.Close(fds[1])
+		m.Close()
+		return nil, err
+	}
+	m.child = os.NewFile(uintptr(fds[1]), "service state")
+	return m, nil
+}
+
+// Start starts the service program like acl_master with the args such as
+// "-f", "xxx.cf", the connection on the state fd 5 and the listeners of
+// the addrs on the listen fds from 6 are given to the service.
+func Start(path string, addrs []string, args ...string) (*Master, error) {
+	m, err := New(addrs...)
+	if err != nil {
+		return nil, err
+	}
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	defer devNull.Close()
+
+	// The fds 3 and 4 are used by acl_master for the flow control.
+	files := []*os.File{devNull, devNull, m.child}
+	for _, ln := range m.listeners {
+		f, err := ln.(*net.TCPListener).File()
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	args = append(args, "-t", "sock", "-s", strconv.Itoa(len(m.listeners)))
+	m.cmd = exec.Command(path, args...)
+	m.cmd.Stdout = os.Stdout
+	m.cmd.Stderr = os.Stderr
+	m.cmd.ExtraFiles = files
+	if err := m.cmd.Start(); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	// The state fd is owned by the service now.
+	_ = m.child.Close()
+	m.child = nil
+
+	m.exited = make(chan struct{})
+	go func() {
+		m.exitErr = m.cmd.Wait()
+		close(m.exited)
+	}()
+	return m, nil
+}
+
+// Option returns the option of master.New to run the service in process
+// with the Master, which can be used only once.
+func (m *Master) Option() master.Option {
+	conn, err := net.FileConn(m.child)
+	if err != nil {
+		panic(fmt.Sprintf("FileConn error: %s", err))
+	}
+	_ = m.child.Close()
+	m.child = nil
+
+	return master.WithMaster(conn, m.listeners...)
+}
+
+// Addrs returns the addresses of the listeners.
+func (m *Master) Addrs() []string {
+	addrs := make([]string, 0, len(m.listeners))
+	for _, ln := range m.listeners {
+		addrs = append(addrs, ln.Addr().String())
+	}
+	return addrs
+}
+
+// Disconnect closes the state connection for writing just like acl_master
+// exits, and the service should stop after draining its connections. The
+// status reported by the service can still be read.
+func (m *Master) Disconnect() error {
+	if conn, ok := m.conn.(*net.UnixConn); ok {
+		return conn.CloseWrite()
+	}
+	return m.conn.Close()
+}
+
+// ReadStatus reads the status reported by the service on the state fd.
+func (m *Master) ReadStatus(timeout time.Duration) (Status, error) {
+	_ = m.conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(m.conn, buf); err != nil {
+		return Status{}, err
+	}
+
+	return Status{
+		Pid:    int(int32(nativeEndian.Uint32(buf[0:]))),
+		Gen:    nativeEndian.Uint32(buf[4:]),
+		Status: int(int32(nativeEndian.Uint32(buf[8:]))),
+	}, nil
+}
+
+// Wait waits for the service program started by Start to exit, and
+// returns its exit error.
+func (m *Master) Wait(timeout time.Duration) error {
+	if m.cmd == nil {
+		return errors.New("service not started by Start")
+	}
+
+	select {
+	case <-m.exited:
+		return m.exitErr
+	case <-time.After(timeout):
+		return fmt.Errorf("service pid=%d not exited in %s", m.cmd.Process.Pid, timeout)
+	}
+}
+
+// Close kills the service program if it's still running, and closes the
+// listeners and the state connection.
+func (m *Master) Close() {
+	if m.cmd != nil {
+		select {
+		case <-m.exited:
+		default:
+			_ = m.cmd.Process.Kill()
+			<-m.exited
+		}
+	}
+
+	for _, ln := range m.listeners {
+		_ = ln.Close()
+	}
+	if m.conn != nil {
+		_ = m.conn.Close()
+	}
+	if m.child != nil {
+		_ = m.child.Close()
+	}
+}
+
+// nativeEndian is the byte order of the host used by the status struct.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
@@ -0,0 +1,164 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package mastertest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	master "github.com/acl-dev/go-service"
+)
+
+const helperEnv = "GO_MASTERTEST_HELPER"
+
+func echo(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write([]byte(line)); err != nil {
+			return
+		}
+	}
+}
+
+// TestHelperService is the service program started by TestStart.
+func TestHelperService(t *testing.T) {
+	if os.Getenv(helperEnv) != "1" {
+		t.Skip("only run as the service program")
+	}
+
+	service, err := master.TcpServiceInit("")
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+	service.AcceptHandler = echo
+	service.Run()
+}
+
+func expectStatus(t *testing.T, m *Master, pid int, status int) {
+	t.Helper()
+	st, err := m.ReadStatus(5 * time.Second)
+	if err != nil {
+		t.Fatalf("ReadStatus error: %s", err)
+	}
+	if st.Pid != pid || st.Status != status {
+		t.Fatalf("Got: %+v, Expect: pid=%d, status=%d", st, pid, status)
+	}
+}
+
+func TestStart(t *testing.T) {
+	os.Setenv(helperEnv, "1")
+	os.Setenv("GENERATION", "12")
+	os.Setenv("GOSERVICE_MASTER_STATUS_NOTIFY", "yes")
+	os.Setenv("GOSERVICE_APP_BUSY_LIMIT", "1")
+	m, err := Start(os.Args[0], []string{"127.0.0.1:0"},
+		"-test.run=^TestHelperService$", "-f", "../testdata/test.cf")
+	os.Unsetenv(helperEnv)
+	os.Unsetenv("GENERATION")
+	os.Unsetenv("GOSERVICE_MASTER_STATUS_NOTIFY")
+	os.Unsetenv("GOSERVICE_APP_BUSY_LIMIT")
+	if err != nil {
+		t.Fatalf("Start error: %s", err)
+	}
+	defer m.Close()
+	pid := m.cmd.Process.Pid
+
+	conn, err := net.Dial("tcp", m.Addrs()[0])
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	_, _ = conn.Write([]byte("hello\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Fatalf("Got: %q, %v, Expect: hello", line, err)
+	}
+
+	st, err := m.ReadStatus(5 * time.Second)
+	if err != nil || st.Gen != 10 || st.Status != StatusTaken {
+		t.Fatalf("Got: %+v, %v, Expect: gen=10, status=%d", st, err, StatusTaken)
+	}
+	conn.Close()
+	expectStatus(t, m, pid, StatusAvail)
+
+	if err := m.Disconnect(); err != nil {
+		t.Fatalf("Disconnect error: %s", err)
+	}
+	expectStatus(t, m, pid, StatusTaken)
+	if err := m.Wait(5 * time.Second); err != nil {
+		t.Fatalf("Wait error: %s", err)
+	}
+}
+
+// runInProcess runs the service with the Master in process, the client
+// connection is kept open until the service closes it.
+func runInProcess(t *testing.T, overrides ...string) (*master.Server, net.Conn, *Master) {
+	m, err := New("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("New error: %s", err)
+	}
+	t.Cleanup(m.Close)
+
+	s := master.New(master.WithConfigFile("../testdata/test.cf"),
+		master.WithOverrides(append(overrides, "master_status_notify=yes", "app_busy_limit=1")...),
+		m.Option())
+	service, err := s.TcpServiceInit("")
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+	service.AcceptHandler = func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	}
+	go service.Run()
+
+	conn, err := net.Dial("tcp", m.Addrs()[0])
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	expectStatus(t, m, os.Getpid(), StatusTaken)
+	return s, conn, m
+}
+
+func TestWaitLimit(t *testing.T) {
+	s, conn, m := runInProcess(t, "app_wait_limit=1")
+
+	start := time.Now()
+	if err := m.Disconnect(); err != nil {
+		t.Fatalf("Disconnect error: %s", err)
+	}
+	s.Wait()
+	if d := time.Since(start); d < 900*time.Millisecond || d > 3*time.Second {
+		t.Fatalf("Got: stopped in %s, Expect: app_wait_limit 1s", d)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Got: %v, Expect: EOF", err)
+	}
+}
+
+func TestQuickAbort(t *testing.T) {
+	s, conn, m := runInProcess(t, "app_wait_limit=10", "app_quick_abort=yes")
+
+	start := time.Now()
+	if err := m.Disconnect(); err != nil {
+		t.Fatalf("Disconnect error: %s", err)
+	}
+	s.Wait()
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("Got: stopped in %s, Expect: at once", d)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Got: %v, Expect: EOF", err)
+	}
+}
@@ -0,0 +1,116 @@
+package master
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	sdNotifySocketEnv = "NOTIFY_SOCKET"
+	sdWatchdogUsecEnv = "WATCHDOG_USEC"
+	sdWatchdogPidEnv  = "WATCHDOG_PID"
+
+	// sdStatusInterval is the interval checking the connections count to
+	// be sent as STATUS=.
+	sdStatusInterval = time.Second
+)
+
+// notifier sends the states of the service to systemd with the socket
+// given by NOTIFY_SOCKET, just like sd_notify(3), which is used when the
+// service runs with Type=notify.
+type notifier struct {
+	conn net.Conn
+}
+
+// newNotifier returns nil if NOTIFY_SOCKET isn't set, the address starting
+// with '@' is the abstract socket.
+func newNotifier() *notifier {
+	addr := os.Getenv(sdNotifySocketEnv)
+	if len(addr) == 0 {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		log.Printf("pid=%d: connect %s error: %s", os.Getpid(), addr, err)
+		return nil
+	}
+	return &notifier{conn: conn}
+}
+
+// notify sends the state such as "READY=1", it does nothing if n is nil.
+func (n *notifier) notify(state string) {
+	if n == nil {
+		return
+	}
+	if _, err := n.conn.Write([]byte(state)); err != nil {
+		log.Printf("pid=%d: notify %q error: %s", os.Getpid(), state, err)
+	}
+}
+
+// sdWatchdogInterval returns the interval sending WATCHDOG=1, which is half
+// of WATCHDOG_USEC, or 0 if the watchdog isn't enabled for the process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv(sdWatchdogUsecEnv), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if value := os.Getenv(sdWatchdogPidEnv); len(value) > 0 {
+		if pid, err := strconv.Atoi(value); err != nil || pid != os.Getpid() {
+			return 0
+		}
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+func connStatus(n int) string {
+	return fmt.Sprintf("STATUS=connections: %d", n)
+}
+
+// startNotify tells systemd that the service is ready, then sends the
+// connections count and pings the watchdog until the Server is stopped.
+// The notifier should be created before the goroutines which may shut
+// down the Server are started.
+func (s *Server) startNotify() {
+	n := s.notifier
+	if n == nil {
+		return
+	}
+
+	last := s.ConnCountCur()
+	n.notify("READY=1\n" + connStatus(last))
+
+	go func() {
+		defer n.conn.Close()
+
+		ticker := time.NewTicker(sdStatusInterval)
+		defer ticker.Stop()
+
+		var watchdog <-chan time.Time
+		if interval := sdWatchdogInterval(); interval > 0 {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			watchdog = t.C
+			n.notify("WATCHDOG=1")
+		}
+
+		for {
+			select {
+			case <-ticker.C:
+				if cur := s.ConnCountCur(); cur != last {
+					last = cur
+					n.notify(connStatus(cur))
+				}
+			case <-watchdog:
+				n.notify("WATCHDOG=1")
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
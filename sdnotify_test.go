@@ -0,0 +1,68 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package master
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram error: %s", err)
+	}
+	defer conn.Close()
+
+	os.Setenv(sdNotifySocketEnv, path)
+	os.Setenv(sdWatchdogUsecEnv, "100000")
+	defer os.Unsetenv(sdNotifySocketEnv)
+	defer os.Unsetenv(sdWatchdogUsecEnv)
+
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone())
+	service, err := s.TcpServiceInit("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+	service.AcceptHandler = func(conn net.Conn) {}
+	go service.Run()
+
+	buf := make([]byte, 1024)
+	recv := func() string {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read error: %s", err)
+		}
+		return string(buf[:n])
+	}
+
+	if state := recv(); state != "READY=1\nSTATUS=connections: 0" {
+		t.Fatalf("Got: %q, Expect: READY=1", state)
+	}
+	for i := 0; i < 2; i++ {
+		if state := recv(); state != "WATCHDOG=1" {
+			t.Fatalf("Got: %q, Expect: WATCHDOG=1", state)
+		}
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %s", err)
+	}
+	for {
+		state := recv()
+		if state == "STOPPING=1" {
+			break
+		}
+		if !strings.HasPrefix(state, "WATCHDOG=") && !strings.HasPrefix(state, "STATUS=") {
+			t.Fatalf("Got: %q, Expect: STOPPING=1", state)
+		}
+	}
+}
@@ -0,0 +1,845 @@
+package master
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	stateFd       = 5
+	listenFdStart = 6
+
+	idleCheckInterval = 100 * time.Millisecond
+)
+
+type PreJailFunc func()
+type InitFunc func()
+type ExitFunc func()
+
+// from command args
+var (
+	Configure    string
+	ServiceName  string
+	ServiceType  string
+	Verbose      bool
+	Unprivileged bool
+	Chroot       = false
+	SocketCount  = 1
+
+	Alone bool
+
+	overrideFlags overrideFlag
+)
+
+// settings are the settings of the framework from the configure.
+type settings struct {
+	service      string
+	logPath      string
+	owner        string
+	args         string
+	rootDir      string
+	useLimit     int
+	idleLimit    int
+	busyLimit    int
+	reusePort    bool
+	quickAbort   bool
+	waitLimit    int
+	forceQuit    bool
+	statusNotify bool
+	accessAllow  string
+	access       *accessList
+	threads      int
+	tlsCertFile  string
+	tlsKeyFile   string
+	unixMode     os.FileMode
+	unixOwner    string
+}
+
+// Server is one service instance which owns its configure, listeners,
+// connections counter, handlers and lifecycle, so more than one service
+// can be run in one process. The package level functions such as Prepare,
+// ServiceInit and OnExit work with the default Server, which also sets
+// the package level variables such as AppConf.
+type Server struct {
+	// from command args
+	args          []string
+	confPath      string
+	service       string
+	sockType      string
+	listenFdCount int
+	privilege     bool
+	verbose       bool
+	chrootOn      bool
+	alone         bool
+	envOverlay    bool
+	overrides     []string
+
+	confMutex sync.RWMutex
+	conf      *Config
+	settings  settings
+
+	prepareMutex  sync.Mutex
+	prepareCalled bool
+	prepareErr    error
+
+	preJailHandler PreJailFunc
+	initHandler    InitFunc
+	exitHandler    ExitFunc
+
+	reloadMutex    sync.Mutex
+	changeHandlers []ConfigChangeFunc
+
+	listeners []net.Listener
+	named     map[string][]net.Listener
+
+	notifier *notifier
+
+	// the connection to acl_master on the state fd in daemon mode
+	stateConn       net.Conn
+	masterListeners []net.Listener
+	statusMutex     sync.Mutex
+	status          *masterStatus
+
+	drainMutex sync.Mutex
+	drainers   []drainer
+
+	connMutex  sync.RWMutex
+	connCount  int
+	lastActive time.Time
+	stopping   int32
+	useCount   int32
+	rejected   int32
+	upgrading  int32
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+
+	stopOnce   sync.Once
+	done       chan struct{}
+	stopResult bool
+
+	isDefault bool
+}
+
+// Option is used to create the Server with New.
+type Option func(*Server)
+
+// WithArgs gives the command args such as "-f xxx.cf -n name -t sock -s 2"
+// which are given by acl_master.
+func WithArgs(args []string) Option {
+	return func(s *Server) {
+		s.args = args
+	}
+}
+
+// WithConfigFile gives the configure file of the service.
+func WithConfigFile(path string) Option {
+	return func(s *Server) {
+		s.confPath = path
+	}
+}
+
+// WithServiceName gives the name of the service, which selects the service
+// block in the configure file.
+func WithServiceName(name string) Option {
+	return func(s *Server) {
+		s.service = name
+	}
+}
+
+// WithAlone makes the service run in alone mode even if it's started by
+// acl_master.
+func WithAlone() Option {
+	return func(s *Server) {
+		s.alone = true
+	}
+}
+
+// WithEnvOverlay makes the entries of master_env overlay the entries of the
+// configure, just like AppEnvOverlay for the default Server.
+func WithEnvOverlay() Option {
+	return func(s *Server) {
+		s.envOverlay = true
+	}
+}
+
+// WithOverrides gives the "key=value" overriding the configure entries,
+// just like -o in the command args.
+func WithOverrides(kvs ...string) Option {
+	return func(s *Server) {
+		s.overrides = append(s.overrides, kvs...)
+	}
+}
+
+// WithMaster makes the service run in daemon mode with the connection to
+// acl_master and the listeners, instead of the fds given by acl_master,
+// which is used to test the daemon mode in process, see mastertest.
+func WithMaster(stateConn net.Conn, listeners ...net.Listener) Option {
+	return func(s *Server) {
+		s.stateConn = stateConn
+		s.masterListeners = listeners
+	}
+}
+
+// New creates one Server with the options, Prepare or ServiceInit should
+// be called later to load its configure.
+func New(opts ...Option) *Server {
+	s := &Server{
+		listenFdCount: 1,
+		done:          make(chan struct{}),
+		settings: settings{
+			waitLimit:   10,
+			forceQuit:   true,
+			accessAllow: "all",
+		},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+var defaultServer = newDefault()
+
+func newDefault() *Server {
+	s := New(WithArgs(os.Args))
+	s.isDefault = true
+	return s
+}
+
+// Default returns the default Server used by the package level functions.
+func Default() *Server {
+	return defaultServer
+}
+
+// initFlags init the command args come from acl_master; the application should call
+// flag.Parse() in its main function!
+func initFlags() {
+	// Just walk through all the internal args to avoid fatal error from flag parser,
+	// but these flags will be ignored, because we'll use the flags parsed in parseArgs().
+
+	flag.StringVar(&Configure, "f", "", "app configure file (internal)")
+	flag.StringVar(&ServiceName, "n", "", "app service name (internal)")
+	flag.StringVar(&ServiceType, "t", "sock", "app service type (internal)")
+	flag.BoolVar(&Alone, "alone", false, "stand alone running (internal)")
+	flag.BoolVar(&Verbose, "v", false, "app verbose (internal)")
+	flag.BoolVar(&Unprivileged, "u", false, "app unprivileged (internal)")
+	flag.BoolVar(&Chroot, "c", false, "app chroot (internal)")
+	flag.IntVar(&SocketCount, "s", 1, "listen fd count (internal)")
+	if flag.Lookup("o") == nil {
+		flag.Var(&overrideFlags, "o", "override configure entry as key=value")
+	}
+	if Verbose {
+		log.Println("service:", ServiceName, "conf:", Configure)
+	}
+}
+
+func init() {
+	initFlags()
+	setOpenMax()
+}
+
+func (s *Server) parseArgs() {
+	args := s.args
+	var n = len(args)
+	for i := 0; i < n; i++ {
+		switch args[i] {
+		case "-f":
+			i++
+			if i < n {
+				s.confPath = args[i]
+			}
+		case "-n":
+			i++
+			if i < n {
+				s.service = args[i]
+			}
+		case "-t":
+			i++
+			if i < n {
+				s.sockType = args[i]
+			}
+		case "-v":
+			s.verbose = true
+		case "-u":
+			s.privilege = true
+		case "-c":
+			s.chrootOn = true
+		case "-s":
+			i++
+			if i < n {
+				s.listenFdCount, _ = strconv.Atoi(args[i])
+			}
+		case "-o":
+			i++
+			if i < n {
+				s.overrides = append(s.overrides, args[i])
+			}
+		default:
+			if strings.HasPrefix(args[i], "-o=") {
+				s.overrides = append(s.overrides, args[i][len("-o="):])
+			}
+		}
+	}
+
+	log.Printf("ListenFdCount=%d, sockType=%s, services=%s",
+		s.listenFdCount, s.sockType, s.service)
+}
+
+// Prepare loads the configure of the Server, it panics if the configure
+// can't be loaded, PrepareE should be used to handle the error.
+func (s *Server) Prepare() {
+	if err := s.PrepareE(); err != nil {
+		panic(err)
+	}
+}
+
+// PrepareE does the same as Prepare, but returns the error when loading the
+// configure, and the same error will be returned when being called again.
+func (s *Server) PrepareE() error {
+	s.prepareMutex.Lock()
+	defer s.prepareMutex.Unlock()
+
+	if s.prepareCalled {
+		return s.prepareErr
+	}
+	s.prepareCalled = true
+
+	s.parseArgs()
+	s.prepareErr = s.loadConf()
+	if s.prepareErr != nil {
+		log.Printf("Load configure %s error: %s", s.confPath, s.prepareErr)
+	}
+	return s.prepareErr
+}
+
+func (s *Server) prepared() bool {
+	s.prepareMutex.Lock()
+	defer s.prepareMutex.Unlock()
+	return s.prepareCalled && s.prepareErr == nil
+}
+
+// getSettings returns the current settings, which may be changed by
+// ReloadConfig.
+func (s *Server) getSettings() settings {
+	s.confMutex.RLock()
+	defer s.confMutex.RUnlock()
+	return s.settings
+}
+
+// isAlone checks if the service should run in alone mode.
+func (s *Server) isAlone() bool {
+	if s.alone || s.isDefault && Alone {
+		return true
+	}
+	if s.stateConn != nil {
+		return false
+	}
+
+	// If sockType has been set by the master service framework, the
+	// service will be started in daemon mode.
+	return len(s.sockType) == 0
+}
+
+// ServiceInit loads the configure, calls the handlers set by OnPreJail
+// and OnInit, and creates the listeners. If addrs not empty, alone mode
+// will be used, or daemon mode be used. The connections from the client
+// IPs not allowed by app_access_allow are closed by the listeners returned
+// before being accepted.
+func (s *Server) ServiceInit(addrs string) ([]net.Listener, error) {
+	if err := s.PrepareE(); err != nil {
+		return nil, err
+	}
+
+	if s.preJailHandler != nil {
+		s.preJailHandler()
+	}
+
+	s.chroot()
+
+	if s.initHandler != nil {
+		s.initHandler()
+	}
+
+	var listeners []net.Listener
+	var daemonMode bool
+
+	// If alone is false and sockType has been set, we'll start the service
+	// in daemon mode, else we'll start the service in alone mode. The sockType
+	// is coming from the the master service framework.
+
+	if !s.isAlone() {
+		var err error
+		st := s.getSettings()
+		if len(s.masterListeners) > 0 {
+			listeners = s.masterListeners
+		} else if st.reusePort && len(st.service) > 0 {
+			listeners, err = s.listenAddrs(st.service)
+		} else {
+			listeners, err = getListeners(s.listenFdCount)
+		}
+		if err != nil {
+			log.Println("GetListeners failed", err)
+			return nil, err
+		}
+		daemonMode = true
+	} else {
+		// The listeners are inherited from the old process when upgrading,
+		// or passed by systemd with socket activation, or else created with
+		// the addrs.
+		var err error
+		listeners, err = inheritedListeners()
+		if err == nil && len(listeners) == 0 {
+			listeners, err = s.systemdListeners(sdListenFdsStart)
+		}
+		if err == nil && len(listeners) == 0 {
+			if len(addrs) == 0 {
+				log.Println("addrs empty in alone running mode")
+				return nil, errors.New("no addresses given in alone running mode")
+			}
+			listeners, err = s.listenAddrs(addrs)
+		}
+		if err != nil {
+			return nil, err
+		}
+		daemonMode = false
+	}
+
+	if len(listeners) == 0 {
+		log.Println("No listener available!")
+		return nil, errors.New("no listener available")
+	}
+
+	if daemonMode && s.stateConn == nil {
+		file := os.NewFile(uintptr(stateFd), "")
+		conn, err := net.FileConn(file)
+		_ = file.Close()
+		if err != nil {
+			log.Printf("pid=%d: FileConn error=%s", os.Getpid(), err)
+			for _, ln := range listeners {
+				_ = ln.Close()
+			}
+			return nil, fmt.Errorf("state fd %d: %s", stateFd, err)
+		}
+		s.stateConn = conn
+	}
+
+	s.listeners = append(s.listeners, listeners...)
+
+	// The notifier is used when shutting down, which may be triggered by
+	// the goroutines below.
+	s.notifier = newNotifier()
+
+	// In daemon mode, the backend monitor fiber will be created for
+	// monitoring the status with the acl_master framework. If disconnected
+	// from acl_master, the current child process will exit.
+	if daemonMode {
+		s.initStatus()
+		go s.monitorMaster()
+	} else {
+		s.watchSignals()
+		s.watchUpgrade()
+	}
+
+	go s.watchReload()
+	go s.watchIdle()
+
+	if !daemonMode {
+		notifyReady()
+	}
+	s.startNotify()
+	return s.accessListeners(listeners), nil
+}
+
+// Listeners returns all the listeners created by ServiceInit, which close
+// the connections not allowed by app_access_allow.
+func (s *Server) Listeners() []net.Listener {
+	return s.accessListeners(s.listeners)
+}
+
+// monitorMaster monitor the PIPE IPC between the current process and acl_master,
+// when acl_master close the PIPE, the current process should exit after
+// which has handled all its tasks
+func (s *Server) monitorMaster() {
+	log.Printf("pid=%d: waiting for master exiting...\r\n", os.Getpid())
+
+	buf := make([]byte, 1024)
+	_, err := s.stateConn.Read(buf)
+	if err != nil {
+		log.Printf("pid=%d: disconnected from master err=%s", os.Getpid(), err.Error())
+	}
+
+	s.shutdownDrain()
+	log.Printf("pid=%d: master service disconnected, exit now\r\n", os.Getpid())
+}
+
+// watchSignals shuts down the Server when receiving SIGTERM or SIGINT in
+// alone mode, just like disconnecting from acl_master in daemon mode.
+func (s *Server) watchSignals() {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go s.handleSignals(ch)
+}
+
+// handleSignals waits for the connections at most app_wait_limit seconds
+// after the first signal, and closes the rest connections at once if the
+// signal is received again and app_force_quit is set.
+func (s *Server) handleSignals(ch chan os.Signal) {
+	defer signal.Stop(ch)
+
+	var sig os.Signal
+	select {
+	case sig = <-ch:
+	case <-s.done:
+		return
+	}
+	log.Printf("pid=%d: got signal %s, shutting down", os.Getpid(), sig)
+
+	ctx, cancel := s.drainContext()
+	defer cancel()
+
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				if s.getSettings().forceQuit {
+					log.Printf("pid=%d: got signal %s again, closing the connections",
+						os.Getpid(), sig)
+					cancel()
+					return
+				}
+				log.Printf("pid=%d: got signal %s again, ignored because app_force_quit is off",
+					os.Getpid(), sig)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("pid=%d: shutdown error: %s", os.Getpid(), err)
+	}
+}
+
+// Stopping checks if the Server is being stopped.
+func (s *Server) Stopping() bool {
+	return atomic.LoadInt32(&s.stopping) != 0
+}
+
+// drainer is implemented by TcpService and WebService, which stop
+// accepting and close their connections when the Server is shut down.
+type drainer interface {
+	// drain waits for the connections to be closed until ctx is done, then
+	// closes the rest by force and returns how many were closed by force.
+	drain(ctx context.Context) int
+}
+
+func (s *Server) addDrainer(d drainer) {
+	s.drainMutex.Lock()
+	s.drainers = append(s.drainers, d)
+	s.drainMutex.Unlock()
+}
+
+// drainContext returns the context limiting the time waiting for the
+// connections when stopping, which is app_wait_limit seconds, or is done
+// already if app_quick_abort is set.
+func (s *Server) drainContext() (context.Context, context.CancelFunc) {
+	st := s.getSettings()
+	if st.quickAbort {
+		log.Printf("pid=%d: app_quick_abort been set", os.Getpid())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx, cancel
+	}
+	if st.waitLimit > 0 {
+		return context.WithTimeout(context.Background(),
+			time.Duration(st.waitLimit)*time.Second)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// watchIdle shuts down the Server after being idle for app_idle_limit
+// seconds, which means no connection is accepted or being handled, so
+// acl_master can reduce the processes.
+func (s *Server) watchIdle() {
+	s.connMutex.Lock()
+	s.lastActive = time.Now()
+	s.connMutex.Unlock()
+
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			limit := s.getSettings().idleLimit
+			if limit <= 0 || s.idleTime() < time.Duration(limit)*time.Second {
+				continue
+			}
+			log.Printf("pid=%d: idle for app_idle_limit %d seconds, exiting",
+				os.Getpid(), limit)
+			s.shutdownDrain()
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// shutdownDrain shuts down the Server waiting for the connections at most
+// app_wait_limit seconds.
+func (s *Server) shutdownDrain() {
+	ctx, cancel := s.drainContext()
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("pid=%d: shutdown error: %s", os.Getpid(), err)
+	}
+}
+
+// accepted is called by the listeners when one connection is accepted, and
+// the Server is shut down after app_use_limit connections are accepted,
+// so the process can be replaced by a fresh one. It also resets the idle
+// timer of app_idle_limit.
+func (s *Server) accepted() {
+	s.connMutex.Lock()
+	s.lastActive = time.Now()
+	s.connMutex.Unlock()
+
+	limit := s.getSettings().useLimit
+	if limit <= 0 {
+		return
+	}
+
+	if atomic.AddInt32(&s.useCount, 1) >= int32(limit) && !s.Stopping() {
+		log.Printf("pid=%d: app_use_limit %d reached, exiting", os.Getpid(), limit)
+		go s.shutdownDrain()
+	}
+}
+
+// Shutdown stops accepting connections, waits for the connections to be
+// closed until ctx is done, then closes the rest by force and stops the
+// Server. It can be called more than once, the later ones wait for the
+// first one and return the same error, which tells how many connections
+// were closed by force.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.shutdown(ctx)
+		s.Stop(true)
+	})
+	return s.shutdownErr
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+	// Set the stopping flag which'll be checked in the end of the service.
+	atomic.StoreInt32(&s.stopping, 1)
+	s.notifier.notify("STOPPING=1")
+	s.updateStatus()
+
+	for _, ln := range s.listeners {
+		log.Printf("pid=%d: closing listener: %s\r\n", os.Getpid(), ln.Addr())
+		_ = ln.Close()
+	}
+
+	s.drainMutex.Lock()
+	drainers := s.drainers
+	s.drainMutex.Unlock()
+
+	if len(drainers) == 0 {
+		// The connections are counted by the application with ConnCountInc
+		// and ConnCountDec, which can't be closed here.
+		if n := waitConns(ctx, s.ConnCountCur); n > 0 {
+			return fmt.Errorf("%d connections left: %w", n, ctx.Err())
+		}
+		return nil
+	}
+
+	var (
+		wg     sync.WaitGroup
+		forced int32
+	)
+	wg.Add(len(drainers))
+	for _, d := range drainers {
+		go func(d drainer) {
+			defer wg.Done()
+			atomic.AddInt32(&forced, int32(d.drain(ctx)))
+		}(d)
+	}
+	wg.Wait()
+
+	if forced > 0 {
+		return fmt.Errorf("%d connections closed by force: %w", forced, ctx.Err())
+	}
+	return nil
+}
+
+// waitConns waits until count returns 0 or ctx is done, and returns the
+// count of the connections left.
+func waitConns(ctx context.Context, count func() int) int {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	start := time.Now()
+	last := start
+	for {
+		n := count()
+		if n <= 0 {
+			return 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return count()
+		case now := <-ticker.C:
+			if now.Sub(last) >= time.Second {
+				last = now
+				log.Printf("pid=%d: exiting, clients=%d, waited=%d seconds\r\n",
+					os.Getpid(), n, int(now.Sub(start).Seconds()))
+			}
+		}
+	}
+}
+
+// Stop stops the Server, the ok is returned by Wait. It can be called more
+// than once but only the first one takes effect.
+func (s *Server) Stop(ok bool) {
+	s.stopOnce.Do(func() {
+		s.stopResult = ok
+		close(s.done)
+	})
+}
+
+// Wait waits until the Server is stopped, and returns the value given by
+// Stop. It can be called more than once.
+func (s *Server) Wait() bool {
+	<-s.done
+	return s.stopResult
+}
+
+func (s *Server) ConnCountInc() {
+	s.connMutex.Lock()
+	s.connCount++
+	s.lastActive = time.Now()
+	s.connMutex.Unlock()
+
+	s.updateStatus()
+}
+
+func (s *Server) ConnCountDec() {
+	s.connMutex.Lock()
+	s.connCount--
+	s.lastActive = time.Now()
+	s.connMutex.Unlock()
+
+	s.updateStatus()
+}
+
+// idleTime returns how long the Server has been idle, which is 0 if there
+// are connections being handled.
+func (s *Server) idleTime() time.Duration {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+	if s.connCount > 0 {
+		return 0
+	}
+	return time.Since(s.lastActive)
+}
+
+func (s *Server) ConnCountCur() int {
+	s.connMutex.RLock()
+	n := s.connCount
+	s.connMutex.RUnlock()
+	return n
+}
+
+func (s *Server) OnPreJail(handler PreJailFunc) {
+	s.preJailHandler = handler
+}
+
+func (s *Server) OnInit(handler InitFunc) {
+	s.initHandler = handler
+}
+
+func (s *Server) OnExit(handler ExitFunc) {
+	s.exitHandler = handler
+}
+
+// Prepare this function can be called automatically in net_service.go or
+// web_service.go to load configure, and it can also be canned in application's
+// main function. It panics if the configure can't be loaded, PrepareE should
+// be used to handle the error.
+func Prepare() {
+	defaultServer.Prepare()
+}
+
+// PrepareE does the same as Prepare, but returns the error when loading the
+// configure, and the same error will be returned when being called again.
+func PrepareE() error {
+	return defaultServer.PrepareE()
+}
+
+func ServiceInit(addrs string) ([]net.Listener, error) {
+	return defaultServer.ServiceInit(addrs)
+}
+
+// GetListeners In acl_master daemon running mode, this function will be called
+// to init the listener handles.
+func GetListeners() ([]net.Listener, error) {
+	return getListeners(defaultServer.listenFdCount)
+}
+
+// ListenersByName returns the listeners of the default Server passed by
+// systemd with the name, see Server.ListenersByName.
+func ListenersByName(name string) []net.Listener {
+	return defaultServer.ListenersByName(name)
+}
+
+func Stop(ok bool) {
+	defaultServer.Stop(ok)
+}
+
+// Shutdown shuts down the default Server, see Server.Shutdown.
+func Shutdown(ctx context.Context) error {
+	return defaultServer.Shutdown(ctx)
+}
+
+func Wait() bool {
+	return defaultServer.Wait()
+}
+
+func ConnCountInc() {
+	defaultServer.ConnCountInc()
+}
+
+func ConnCountDec() {
+	defaultServer.ConnCountDec()
+}
+
+func ConnCountCur() int {
+	return defaultServer.ConnCountCur()
+}
+
+func OnPreJail(handler PreJailFunc) {
+	defaultServer.OnPreJail(handler)
+}
+
+func OnInit(handler InitFunc) {
+	defaultServer.OnInit(handler)
+}
+
+func OnExit(handler ExitFunc) {
+	defaultServer.OnExit(handler)
+}
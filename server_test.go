@@ -0,0 +1,365 @@
+package master
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServerInstances(t *testing.T) {
+	a := New(WithConfigFile("testdata/test.cf"), WithOverrides("app_wait_limit=3"))
+	b := New(WithConfigFile("testdata/test.cf"), WithOverrides("app_wait_limit=7"))
+	if err := a.PrepareE(); err != nil {
+		t.Fatalf("PrepareE error: %s", err)
+	}
+	if err := b.PrepareE(); err != nil {
+		t.Fatalf("PrepareE error: %s", err)
+	}
+
+	if n := a.getSettings().waitLimit; n != 3 {
+		t.Fatalf("Got: %d, Expect: 3", n)
+	}
+	if n := b.getSettings().waitLimit; n != 7 {
+		t.Fatalf("Got: %d, Expect: 7", n)
+	}
+
+	a.ConnCountInc()
+	a.ConnCountInc()
+	b.ConnCountInc()
+	a.ConnCountDec()
+	if a.ConnCountCur() != 1 || b.ConnCountCur() != 1 {
+		t.Fatalf("Got: %d, %d, Expect: 1, 1", a.ConnCountCur(), b.ConnCountCur())
+	}
+
+	a.Stop(true)
+	a.Stop(false)
+	if !a.Wait() || !a.Wait() {
+		t.Fatalf("Wait Got: false, Expect: true")
+	}
+
+	select {
+	case <-b.done:
+		t.Fatalf("the other Server stopped")
+	default:
+	}
+}
+
+func TestServerPrepareError(t *testing.T) {
+	s := New(WithConfigFile("testdata/none.cf"))
+	err := s.PrepareE()
+	if err == nil {
+		t.Fatalf("PrepareE with missing file ok, Expect error")
+	}
+	if s.PrepareE() != err {
+		t.Fatalf("PrepareE Got a different error when called again")
+	}
+}
+
+func TestTcpServiceShutdown(t *testing.T) {
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone(),
+		WithOverrides("app_wait_limit=1"))
+	service, err := s.TcpServiceInit("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+
+	accepted := make(chan struct{})
+	service.AcceptHandler = func(conn net.Conn) {
+		close(accepted)
+		buf := make([]byte, 1)
+		_, _ = conn.Read(buf)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- service.RunContext(ctx)
+	}()
+
+	conn, err := net.Dial("tcp", service.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	defer conn.Close()
+	<-accepted
+
+	cancel()
+	err = <-result
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Got: %v, Expect: deadline exceeded", err)
+	}
+	if !strings.HasPrefix(err.Error(), "1 connections closed by force") {
+		t.Fatalf("Got: %s, Expect: 1 connections closed by force", err)
+	}
+	if err2 := service.Shutdown(context.Background()); err2 != err {
+		t.Fatalf("Got: %v, Expect the same error %v", err2, err)
+	}
+	if !s.Stopping() || !s.Wait() {
+		t.Fatalf("Server not stopped")
+	}
+}
+
+func TestWebServiceShutdown(t *testing.T) {
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone())
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	service, err := s.WebServiceInit("127.0.0.1:0", handler)
+	if err != nil {
+		t.Fatalf("WebServiceInit error: %s", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- service.RunContext(context.Background())
+	}()
+
+	// Keep one idle connection which should be closed by Shutdown.
+	client := &http.Client{Transport: &http.Transport{}}
+	resp, err := client.Get("http://" + service.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := service.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown error: %s", err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("RunContext error: %s", err)
+	}
+}
+
+func TestAloneSignals(t *testing.T) {
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone(),
+		WithOverrides("app_wait_limit=0", "app_force_quit=yes"))
+	service, err := s.TcpServiceInit("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+
+	accepted := make(chan struct{})
+	service.AcceptHandler = func(conn net.Conn) {
+		close(accepted)
+		buf := make([]byte, 1)
+		_, _ = conn.Read(buf)
+	}
+	go service.Run()
+
+	conn, err := net.Dial("tcp", service.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	defer conn.Close()
+	<-accepted
+
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		t.Skipf("Signal error: %s", err)
+	}
+	for !s.Stopping() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The connection is kept until the signal is received again.
+	select {
+	case <-s.done:
+		t.Fatalf("Server stopped with the connection alive")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	_ = p.Signal(syscall.SIGTERM)
+	if !s.Wait() {
+		t.Fatalf("Wait Got: false, Expect: true")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Got: %v, Expect: EOF", err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("upgrade not supported")
+	}
+
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone())
+	service, err := s.TcpServiceInit("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+
+	// Being the new process started by Upgrade below.
+	if inherited := len(service.listeners); os.Getenv("GO_SERVICE_TEST_UPGRADE") != "" {
+		service.AcceptHandler = func(conn net.Conn) {
+			_, _ = conn.Write([]byte(strconv.Itoa(inherited)))
+			s.Stop(true)
+		}
+		service.Run()
+		return
+	}
+
+	service.AcceptHandler = func(conn net.Conn) {
+		_, _ = conn.Write([]byte("old"))
+	}
+	go service.Run()
+
+	addr := service.listeners[0].Addr().String()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile error: %s", err)
+	}
+	defer devNull.Close()
+
+	savedArgs, savedStdout := os.Args, os.Stdout
+	os.Args = []string{os.Args[0], "-test.run=^TestUpgrade$"}
+	os.Stdout = devNull
+	os.Setenv("GO_SERVICE_TEST_UPGRADE", "yes")
+	err = s.Upgrade()
+	os.Unsetenv("GO_SERVICE_TEST_UPGRADE")
+	os.Args, os.Stdout = savedArgs, savedStdout
+	if err != nil {
+		t.Fatalf("Upgrade error: %s", err)
+	}
+	s.Wait()
+
+	// The connection is accepted by the new process with the inherited
+	// listener.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, _ := io.ReadAll(conn)
+	if string(data) != "1" {
+		t.Fatalf("Got: %q, Expect: \"1\"", data)
+	}
+}
+
+func TestUseLimit(t *testing.T) {
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone(),
+		WithOverrides("app_use_limit=2"))
+	service, err := s.TcpServiceInit("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+	service.AcceptHandler = func(conn net.Conn) {
+		_, _ = conn.Write([]byte("ok"))
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- service.RunContext(context.Background())
+	}()
+
+	addr := service.listeners[0].Addr().String()
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial error: %s", err)
+		}
+		_, _ = io.ReadAll(conn)
+		conn.Close()
+	}
+
+	select {
+	case <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("service not stopped after app_use_limit connections")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatalf("Dial ok after app_use_limit connections, Expect error")
+	}
+}
+
+func TestUseLimitListener(t *testing.T) {
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone(),
+		WithOverrides("app_use_limit=2"))
+	listeners, err := s.ServiceInit("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ServiceInit error: %s", err)
+	}
+
+	// Serve with the raw listener just like the gin or grpc servers.
+	go func() {
+		for {
+			conn, err := listeners[0].Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	addr := listeners[0].Addr().String()
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial error: %s", err)
+		}
+		_, _ = io.ReadAll(conn)
+		conn.Close()
+	}
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- s.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Server not stopped after app_use_limit connections")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatalf("Dial ok after app_use_limit connections, Expect error")
+	}
+}
+
+func TestIdleLimit(t *testing.T) {
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone(),
+		WithOverrides("app_idle_limit=1"))
+	service, err := s.TcpServiceInit("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+	service.AcceptHandler = func(conn net.Conn) {
+		_, _ = conn.Read(make([]byte, 1))
+	}
+
+	start := time.Now()
+	go service.Run()
+
+	// The Server isn't idle while the connection is alive.
+	conn, err := net.Dial("tcp", service.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if s.Stopping() {
+		t.Fatalf("Server stopped with the connection alive")
+	}
+	conn.Close()
+
+	closed := time.Now()
+	s.Wait()
+	if d := time.Since(closed); d < 900*time.Millisecond {
+		t.Fatalf("Got: stopped %s after being idle, Expect: 1s", d)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("Got: stopped after %s, Expect: about 2.5s", d)
+	}
+}
@@ -6,61 +6,16 @@ package master
 import (
 	"context"
 	"errors"
-	"flag"
 	"fmt"
 	"golang.org/x/sys/unix"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"os/user"
 	"strconv"
 	"strings"
-	"sync"
 	"syscall"
-	"time"
-)
-
-const (
-	stateFd       = 5
-	listenFdStart = 6
-)
-
-var (
-	listenFdCount = 1
-	confPath      string
-	sockType      string
-	services      string
-	privilege     = false
-	verbose       = false
-	chrootOn      = false
-)
-
-type PreJailFunc func()
-type InitFunc func()
-type ExitFunc func()
-
-var (
-	preJailHandler PreJailFunc = nil
-	initHandler    InitFunc    = nil
-	exitHandler    ExitFunc    = nil
-	doneChan                   = make(chan bool)
-	connCount                  = 0
-	connMutex      sync.RWMutex
-	stopping       = false
-	prepareCalled  = false
-)
-
-// from command args
-var (
-	Configure    string
-	ServiceName  string
-	ServiceType  string
-	Verbose      bool
-	Unprivileged bool
-	Chroot       = false
-	SocketCount  = 1
-
-	Alone bool
 )
 
 // setOpenMax set the max opened file handles for current process which let
@@ -83,89 +38,15 @@ func setOpenMax() {
 	}
 }
 
-// initFlags init the command args come from acl_master; the application should call
-// flag.Parse() in its main function!
-func initFlags() {
-	// Just walk through all the internal args to avoid fatal error from flag parser,
-	// but these flags will be ignored, because we'll use the flags parsed in parseArgs().
-
-	flag.StringVar(&Configure, "f", "", "app configure file (internal)")
-	flag.StringVar(&ServiceName, "n", "", "app service name (internal)")
-	flag.StringVar(&ServiceType, "t", "sock", "app service type (internal)")
-	flag.BoolVar(&Alone, "alone", false, "stand alone running (internal)")
-	flag.BoolVar(&Verbose, "v", false, "app verbose (internal)")
-	flag.BoolVar(&Unprivileged, "u", false, "app unprivileged (internal)")
-	flag.BoolVar(&Chroot, "c", false, "app chroot (internal)")
-	flag.IntVar(&SocketCount, "s", 1, "listen fd count (internal)")
-	if Verbose || verbose {
-		log.Println("service:", ServiceName, "conf:", Configure)
-	}
-}
-
-func init() {
-	initFlags()
-	setOpenMax()
-}
-
-func parseArgs() {
-	var n = len(os.Args)
-	for i := 0; i < n; i++ {
-		switch os.Args[i] {
-		case "-f":
-			i++
-			if i < n {
-				confPath = os.Args[i]
-			}
-		case "-n":
-			i++
-			if i < n {
-				services = os.Args[i]
-			}
-		case "-t":
-			i++
-			if i < n {
-				sockType = os.Args[i]
-			}
-		case "-v":
-			verbose = true
-		case "-u":
-			privilege = true
-		case "-c":
-			chrootOn = true
-		case "-s":
-			i++
-			if i < n {
-				listenFdCount, _ = strconv.Atoi(os.Args[i])
-			}
-		}
-	}
-
-	log.Printf("ListenFdCount=%d, sockType=%s, services=%s",
-		listenFdCount, sockType, services)
-}
-
-// Prepare this function can be called automatically in net_service.go or
-// web_service.go to load configure, and it can also be canned in application's
-// main function
-func Prepare() {
-	if prepareCalled {
+func (s *Server) chroot() {
+	st := s.getSettings()
+	if len(st.args) == 0 || !s.privilege || len(st.owner) == 0 {
 		return
-	} else {
-		prepareCalled = true
 	}
 
-	parseArgs()
-	loadConf(confPath)
-}
-
-func chroot() {
-	if len(AppArgs) == 0 || !privilege || len(AppOwner) == 0 {
-		return
-	}
-
-	u, err := user.Lookup(AppOwner)
+	u, err := user.Lookup(st.owner)
 	if err != nil {
-		log.Printf("Lookup %s error %s", AppOwner, err)
+		log.Printf("Lookup %s error %s", st.owner, err)
 	} else {
 		gid, err := strconv.Atoi(u.Gid)
 		if err != nil {
@@ -186,7 +67,7 @@ func chroot() {
 		}
 	}
 
-	if chrootOn && len(AppRootDir) > 0 {
+	if s.chrootOn && len(st.rootDir) > 0 {
 		// The system call chroot can't work correctly on Linux.
 		// In golang issue 1435 from the Go source comments.
 		// On linux Setuid and Setgid only affects the current thread,
@@ -196,11 +77,11 @@ func chroot() {
 		// But I wrote a sample that using setuid and setgid after
 		// creating some threads, thease threads' uid and gid were
 		// changed to the uid or gid by calling setuid and setgid, why?
-		err := syscall.Chroot(AppRootDir)
+		err := syscall.Chroot(st.rootDir)
 		if err != nil {
-			log.Printf("Chroot error %s, path %s", err, AppRootDir)
+			log.Printf("Chroot error %s, path %s", err, st.rootDir)
 		} else {
-			log.Printf("Chroot ok, path %s", AppRootDir)
+			log.Printf("Chroot ok, path %s", st.rootDir)
 			err := syscall.Chdir("/")
 			if err != nil {
 				log.Printf("Chdir error %s", err)
@@ -214,47 +95,134 @@ func chroot() {
 // GetListenersByAddrs In run alone mode, the application should give the
 // listening addrs and call this function to listen the given addrs
 func GetListenersByAddrs(addrs string) ([]net.Listener, error) {
-	if len(addrs) == 0 {
-		log.Println("No valid addrs for listening")
-		return nil, errors.New("no valid addrs for listening")
+	return defaultServer.listenAddrs(addrs)
+}
+
+// listen listens the address, SO_REUSEPORT is set for TCP unless the
+// option reuseport is off.
+func (s *Server) listen(a ListenAddr) (net.Listener, error) {
+	if a.Network == "unix" {
+		return s.listenUnix(a.Host, a.Options)
 	}
 
-	// split addrs like "xxx.xxx.xxx.xxx:port; xxx.xxx.xxx.xxx:port"
+	addr, err := a.address()
+	if err != nil {
+		return nil, err
+	}
 
-	addrs = strings.Replace(addrs, " ", "", -1)
-	addrs = strings.Replace(addrs, ",", ";", -1)
-	addrs = strings.Replace(addrs, "|", ":", -1)
-	tokens := strings.Split(addrs, ";")
+	reusePort := true
+	if value, found := a.Options["reuseport"]; found {
+		reusePort, _ = parseBool(value)
+	}
 
 	cfg := net.ListenConfig{
 		Control: func(network, address string, c syscall.RawConn) error {
 			return c.Control(func(fd uintptr) {
 				syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, unix.SO_REUSEADDR, 1)
-				syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+				if reusePort {
+					syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+				}
 			})
 		},
 	}
-	listeners := []net.Listener(nil)
-	for _, addr := range tokens {
-		ln, err := cfg.Listen(context.Background(), "tcp", addr)
-		if err == nil {
-			listeners = append(listeners, ln)
-			log.Printf("Listen %s ok\r\n", addr)
-			continue
+	return cfg.Listen(context.Background(), a.Network, addr)
+}
+
+// listenUnix listens the unix domain socket, the stale socket file left
+// by the process exited abnormally is removed first. The mode and owner
+// of the socket file are set with the options "mode" and "owner", or else
+// with app_unix_mode and app_unix_owner, and the file is removed when the
+// listener is closed.
+func (s *Server) listenUnix(path string, options map[string]string) (net.Listener, error) {
+	abstract := strings.HasPrefix(path, "@")
+	if !abstract {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
 		}
+	}
 
-		log.Println("Listen", addr, "error:", err)
+	ln, err := net.Listen("unix", path)
+	if err != nil || abstract {
+		return ln, err
 	}
 
-	if len(listeners) == 0 {
-		return nil, errors.New("no listeners were created")
+	st := s.getSettings()
+	mode, owner := st.unixMode, st.unixOwner
+	if value, found := options["mode"]; found {
+		m, _ := strconv.ParseUint(value, 8, 32)
+		mode = os.FileMode(m)
 	}
-	return listeners, nil
+	if value, found := options["owner"]; found {
+		owner = value
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+	if len(owner) > 0 {
+		if err := chownSocket(path, owner); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
 }
 
-// GetListeners In acl_master daemon running mode, this function will be called
-// to init the listener handles.
-func GetListeners() ([]net.Listener, error) {
+// removeStaleSocket removes the socket file if no one is listening on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and isn't a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is being listened by another process", path)
+	}
+
+	log.Printf("pid=%d: remove the stale socket %s", os.Getpid(), path)
+	return os.Remove(path)
+}
+
+// chownSocket sets the owner of the socket file, the owner is "user" or
+// "user:group".
+func chownSocket(path, owner string) error {
+	name, group := owner, ""
+	if pos := strings.IndexByte(owner, ':'); pos >= 0 {
+		name, group = owner[:pos], owner[pos+1:]
+	}
+
+	uid, gid := -1, -1
+	if len(name) > 0 {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if len(group) > 0 {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// getListeners creates the listeners from the fds given by acl_master.
+func getListeners(listenFdCount int) ([]net.Listener, error) {
 	listeners := []net.Listener(nil)
 	for fd := listenFdStart; fd < listenFdStart+listenFdCount; fd++ {
 		file := os.NewFile(uintptr(fd), "open one listen fd")
@@ -290,169 +258,20 @@ func GetListeners() ([]net.Listener, error) {
 	return listeners, nil
 }
 
-func ServiceInit(addrs string) ([]net.Listener, error) {
-	Prepare()
-
-	if preJailHandler != nil {
-		preJailHandler()
-	}
-
-	chroot()
-
-	if initHandler != nil {
-		initHandler()
-	}
-
-	// if addrs not empty, alone mode will be used, or daemon mode be used
-
-	var listeners []net.Listener
-	var daemonMode bool
-
-	// If alone is false and sockType has been set, we'll start the service
-	// in daemon mode, else we'll start the service in alone mode. The sockType
-	// is coming from the the master service framework.
-
-	if !Alone && len(sockType) > 0 {
-		var err error
-		if AppReusePort && len(AppService) > 0 {
-			listeners, err = GetListenersByAddrs(AppService)
-		} else {
-			listeners, err = GetListeners()
-		}
-		if err != nil {
-			log.Println("GetListeners failed", err)
-			return nil, err
+// watchReload reloads the configure when receiving SIGHUP, until the
+// Server is stopped.
+func (s *Server) watchReload() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ch:
+			log.Printf("pid=%d: got SIGHUP, reloading configure", os.Getpid())
+			_ = s.ReloadConfig()
+		case <-s.done:
+			return
 		}
-		daemonMode = true
-	} else if len(addrs) > 0 {
-		var err error
-		listeners, err = GetListenersByAddrs(addrs)
-		if err != nil {
-			return nil, err
-		}
-		daemonMode = false
-	} else {
-		log.Println("addrs empty in alone running mode")
-		return nil, errors.New("no addresses given in alone running mode")
-	}
-
-	if len(listeners) == 0 {
-		log.Println("No listener available!")
-		return nil, errors.New("no listener available")
-	}
-
-	// In daemon mode, the backend monitor fiber will be created for
-	// monitoring the status with the acl_master framework. If disconnected
-	// from acl_master, the current child process will exit.
-	if daemonMode {
-		go monitorMaster(listeners)
 	}
-	return listeners, nil
-}
-
-// monitorMaster monitor the PIPE IPC between the current process and acl_master,
-// when acl_master close the PIPE, the current process should exit after
-// which has handled all its tasks
-func monitorMaster(listeners []net.Listener) {
-
-	file := os.NewFile(uintptr(stateFd), "")
-	conn, err := net.FileConn(file)
-	if err != nil {
-		panic(fmt.Sprintf("pid=%d: FileConn error=%s", os.Getpid(), err))
-	}
-
-	log.Printf("pid=%d: waiting for master exiting...\r\n", os.Getpid())
-
-	buf := make([]byte, 1024)
-	_, err = conn.Read(buf)
-	if err != nil {
-		log.Printf("pid=%d: disconnected from master err=%s", os.Getpid(), err.Error())
-	}
-
-	// Set the stopping flag which'll be checked in the end of the service.
-	stopping = true
-
-	// XXX: Force stopping listen.
-	for _, ln := range listeners {
-		log.Printf("pid=%d: closing listener: %s\r\n", os.Getpid(), ln.Addr())
-		_ = ln.Close()
-	}
-
-	var n, i int
-	n = 0
-	i = 0
-
-	if AppQuickAbort {
-		log.Printf("pid=%d: app_quick_abort been set", os.Getpid())
-	} else {
-		for {
-			connMutex.RLock()
-			if connCount <= 0 {
-				connMutex.RUnlock()
-				break
-			}
-
-			n = connCount
-			connMutex.RUnlock()
-			time.Sleep(time.Second) // sleep 1 second
-			i++
-			log.Printf("pid=%d: exiting, clients=%d, sleep=%d seconds\r\n",
-				os.Getpid(), n, i)
-			if AppWaitLimit > 0 && i >= AppWaitLimit {
-				log.Printf("waiting too long >= %d", AppWaitLimit)
-				break
-			}
-		}
-	}
-
-	log.Printf("pid=%d: master service disconnected, exit now\r\n", os.Getpid())
-	Stop(true)
-}
-
-func Stop(ok bool) {
-	if doneChan != nil {
-		doneChan <- ok
-	}
-}
-
-func Wait() bool {
-	if doneChan != nil {
-		res := <-doneChan
-		close(doneChan)
-		doneChan = nil
-		return res
-	} else {
-		return true
-	}
-}
-
-func ConnCountInc() {
-	connMutex.Lock()
-	connCount++
-	connMutex.Unlock()
-}
-
-func ConnCountDec() {
-	connMutex.Lock()
-	connCount--
-	connMutex.Unlock()
-}
-
-func ConnCountCur() int {
-	connMutex.RLock()
-	n := connCount
-	connMutex.RUnlock()
-	return n
-}
-
-func OnPreJail(handler PreJailFunc) {
-	preJailHandler = handler
-}
-
-func OnInit(handler InitFunc) {
-	initHandler = handler
-}
-
-func OnExit(handler ExitFunc) {
-	exitHandler = handler
 }
@@ -0,0 +1,143 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package master
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestUnixSocketPath(t *testing.T) {
+	tests := []struct {
+		addr string
+		path string
+		ok   bool
+	}{
+		{"unix:/run/app.sock", "/run/app.sock", true},
+		{"unix:app", "app", true},
+		{"/run/app", "/run/app", true},
+		{"go-httpd.sock", "go-httpd.sock", true},
+		{"@app", "@app", true},
+		{"127.0.0.1|8080", "", false},
+		{"127.0.0.1:8080", "", false},
+	}
+
+	for _, test := range tests {
+		path, ok := unixSocketPath(test.addr)
+		if path != test.path || ok != test.ok {
+			t.Fatalf("%s Got: %q, %v, Expect: %q, %v",
+				test.addr, path, ok, test.path, test.ok)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+
+	// Leave the stale socket file.
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Listen error: %s", err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	s := New(WithConfigFile("testdata/test.cf"), WithAlone(),
+		WithOverrides("app_unix_mode=0600"))
+	service, err := s.TcpServiceInit("127.0.0.1:0, unix:" + path)
+	if err != nil {
+		t.Fatalf("TcpServiceInit error: %s", err)
+	}
+	service.AcceptHandler = func(conn net.Conn) {
+		_, _ = conn.Write([]byte("ok"))
+	}
+	go service.Run()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat error: %s", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("Got: %o, Expect: 600", fi.Mode().Perm())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	buf := make([]byte, 2)
+	if n, _ := conn.Read(buf); string(buf[:n]) != "ok" {
+		t.Fatalf("Got: %q, Expect: ok", buf[:n])
+	}
+	conn.Close()
+
+	// The socket is being listened.
+	if _, err := New().listenUnix(path, nil); err == nil {
+		t.Fatalf("listenUnix %s again ok, Expect error", path)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Got: %v, Expect: %s removed", err, path)
+	}
+}
+
+func TestListenAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract socket only on linux")
+	}
+
+	ln, err := New().listenUnix("@go-service-test", nil)
+	if err != nil {
+		t.Fatalf("listenUnix error: %s", err)
+	}
+	defer ln.Close()
+
+	conn, err := net.Dial("unix", "@go-service-test")
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	conn.Close()
+}
+
+func TestInheritedUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Listen error: %s", err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	defer ln.Close()
+
+	// The fd is closed by inheritedListeners.
+	f, err := ln.(*net.UnixListener).File()
+	if err != nil {
+		t.Fatalf("File error: %s", err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatalf("Dup error: %s", err)
+	}
+
+	os.Setenv(inheritFdsEnv, strconv.Itoa(fd))
+	listeners, err := inheritedListeners()
+	if err != nil || len(listeners) != 1 {
+		t.Fatalf("Got: %v, %v, Expect: one listener", listeners, err)
+	}
+
+	// The socket file is removed when the inherited listener is closed.
+	listeners[0].Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Got: %v, Expect: the socket file removed", err)
+	}
+}
@@ -0,0 +1,120 @@
+package master
+
+import (
+	"encoding/binary"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"unsafe"
+)
+
+// The status of the process reported to acl_master, the same as
+// ACL_MASTER_STAT_TAKEN and ACL_MASTER_STAT_AVAIL of acl.
+const (
+	statusTaken = 0
+	statusAvail = 1
+)
+
+// generationEnv is the environment variable of the process generation
+// given by acl_master in octal.
+const generationEnv = "GENERATION"
+
+// nativeEndian is the byte order of the host, which is used by acl_master
+// to read the status struct.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// masterStatus is the status last reported to acl_master.
+type masterStatus struct {
+	gen  uint32
+	last int32
+}
+
+// statusMessage encodes the status as ACL_MASTER_STATUS of acl:
+// struct { int pid; unsigned gen; int avail; }.
+func statusMessage(pid int, gen uint32, status int32) []byte {
+	buf := make([]byte, 12)
+	nativeEndian.PutUint32(buf[0:], uint32(int32(pid)))
+	nativeEndian.PutUint32(buf[4:], gen)
+	nativeEndian.PutUint32(buf[8:], uint32(status))
+	return buf
+}
+
+// processGeneration returns the generation given by acl_master.
+func processGeneration() uint32 {
+	value := os.Getenv(generationEnv)
+	if len(value) == 0 {
+		return 0
+	}
+
+	gen, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		log.Printf("pid=%d: invalid %s=%s", os.Getpid(), generationEnv, value)
+		return 0
+	}
+	return uint32(gen)
+}
+
+// initStatus enables reporting the status to acl_master on the state fd if
+// master_status_notify is set, which also tells acl_master to read it. The
+// process is available when being started by acl_master, so nothing is
+// reported here.
+func (s *Server) initStatus() {
+	if s.stateConn == nil || !s.getSettings().statusNotify {
+		return
+	}
+
+	s.statusMutex.Lock()
+	s.status = &masterStatus{gen: processGeneration(), last: statusAvail}
+	s.statusMutex.Unlock()
+}
+
+// updateStatus reports to acl_master that the process is taken when it's
+// busy, so acl_master can start more processes up to master_maxproc, and
+// reports that it's available again when it's not busy.
+func (s *Server) updateStatus() {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	if s.status == nil {
+		return
+	}
+
+	status := int32(statusAvail)
+	if s.busy() {
+		status = statusTaken
+	}
+	if status == s.status.last {
+		return
+	}
+
+	msg := statusMessage(os.Getpid(), s.status.gen, status)
+	if _, err := s.stateConn.Write(msg); err != nil {
+		log.Printf("pid=%d: report status %d error: %s", os.Getpid(), status, err)
+		return
+	}
+	s.status.last = status
+}
+
+// busy checks if the process is busy. One process handles many connections,
+// so it's busy only when the connections reach app_busy_limit. The status
+// struct has no room for the use count, so the process is also busy when
+// app_use_limit is reached or it's going to exit, which means it won't
+// accept more connections.
+func (s *Server) busy() bool {
+	if s.Stopping() {
+		return true
+	}
+
+	st := s.getSettings()
+	if st.busyLimit > 0 && s.ConnCountCur() >= st.busyLimit {
+		return true
+	}
+	return st.useLimit > 0 && atomic.LoadInt32(&s.useCount) >= int32(st.useLimit)
+}
@@ -0,0 +1,89 @@
+package master
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMasterStatus(t *testing.T) {
+	os.Setenv(generationEnv, "17")
+	defer os.Unsetenv(generationEnv)
+
+	s := New(WithConfigFile("testdata/test.cf"),
+		WithOverrides("master_status_notify=yes", "app_busy_limit=2",
+			"app_use_limit=100"))
+	if err := s.PrepareE(); err != nil {
+		t.Fatalf("PrepareE error: %s", err)
+	}
+
+	master, child := net.Pipe()
+	defer master.Close()
+	s.stateConn = child
+	s.initStatus()
+
+	msgs := make(chan []byte, 10)
+	go func() {
+		for {
+			buf := make([]byte, 12)
+			if _, err := io.ReadFull(master, buf); err != nil {
+				close(msgs)
+				return
+			}
+			msgs <- buf
+		}
+	}()
+
+	expect := func(status int32) {
+		t.Helper()
+		msg := <-msgs
+		if string(msg) != string(statusMessage(os.Getpid(), 15, status)) {
+			t.Fatalf("Got: %v, Expect status %d", msg, status)
+		}
+	}
+
+	// The process is busy when the connections reach app_busy_limit.
+	s.ConnCountInc()
+	s.ConnCountInc()
+	expect(statusTaken)
+	s.ConnCountDec()
+	expect(statusAvail)
+	s.ConnCountDec()
+
+	// The process is busy when app_use_limit is reached.
+	atomic.StoreInt32(&s.useCount, 100)
+	s.updateStatus()
+	expect(statusTaken)
+	atomic.StoreInt32(&s.useCount, 0)
+	s.updateStatus()
+	expect(statusAvail)
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %s", err)
+	}
+	expect(statusTaken)
+
+	child.Close()
+	if msg, ok := <-msgs; ok {
+		t.Fatalf("Got: %v, Expect nothing more", msg)
+	}
+}
+
+func TestMasterStatusDisabled(t *testing.T) {
+	s := New(WithConfigFile("testdata/test.cf"),
+		WithOverrides("master_status_notify=no"))
+	if err := s.PrepareE(); err != nil {
+		t.Fatalf("PrepareE error: %s", err)
+	}
+
+	_, child := net.Pipe()
+	s.stateConn = child
+	s.initStatus()
+
+	// The pipe blocks the writing if anything is reported.
+	s.ConnCountInc()
+	s.ConnCountDec()
+}
@@ -0,0 +1,98 @@
+package master
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// sdListenFdsStart is the first fd passed by systemd, the same as
+	// SD_LISTEN_FDS_START of sd_listen_fds(3).
+	sdListenFdsStart = 3
+
+	sdListenPidEnv     = "LISTEN_PID"
+	sdListenFdsEnv     = "LISTEN_FDS"
+	sdListenFdNamesEnv = "LISTEN_FDNAMES"
+
+	// sdUnknownName is the name of the fd without FileDescriptorName=.
+	sdUnknownName = "unknown"
+)
+
+// systemdListeners creates the listeners from the fds passed by systemd
+// with socket activation, or returns nil if the process isn't activated
+// by systemd. The listeners are named by FileDescriptorName= of the
+// socket units, and the LISTEN_* environment variables are unset so they
+// won't be passed to the child processes.
+func (s *Server) systemdListeners(start int) ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv(sdListenPidEnv))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	value := os.Getenv(sdListenFdsEnv)
+	count, err := strconv.Atoi(value)
+	if err != nil || count <= 0 {
+		log.Printf("pid=%d: invalid %s=%s", os.Getpid(), sdListenFdsEnv, value)
+		return nil, nil
+	}
+
+	var names []string
+	if value := os.Getenv(sdListenFdNamesEnv); len(value) > 0 {
+		names = strings.Split(value, ":")
+	}
+
+	_ = os.Unsetenv(sdListenPidEnv)
+	_ = os.Unsetenv(sdListenFdsEnv)
+	_ = os.Unsetenv(sdListenFdNamesEnv)
+
+	listeners := []net.Listener(nil)
+	for i := 0; i < count; i++ {
+		fd := start + i
+		name := sdUnknownName
+		if i < len(names) && len(names[i]) > 0 {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(file)
+
+		// fd will be dupped in FileListener, so we should close it
+		// after the listener is created
+		_ = file.Close()
+
+		if err != nil {
+			log.Printf("pid=%d: create listener %s from systemd fd=%d error: %s",
+				os.Getpid(), name, fd, err)
+			continue
+		}
+
+		log.Printf("pid=%d: listen %s from systemd fd=%d, name=%s",
+			os.Getpid(), ln.Addr(), fd, name)
+		listeners = append(listeners, ln)
+
+		if s.named == nil {
+			s.named = make(map[string][]net.Listener)
+		}
+		s.named[name] = append(s.named[name], ln)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("no listener created from systemd")
+	}
+	return listeners, nil
+}
+
+// ListenersByName returns the listeners passed by systemd with the name
+// given by FileDescriptorName= of the socket units, the name of the ones
+// without FileDescriptorName= is "unknown". The connections not allowed by
+// app_access_allow are closed by them.
+func (s *Server) ListenersByName(name string) []net.Listener {
+	if listeners, found := s.named[name]; found {
+		return s.accessListeners(listeners)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package master
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSystemdListeners(t *testing.T) {
+	const start = 100
+
+	for i, addr := range []string{"127.0.0.1:0", "127.0.0.1:0"} {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatalf("Listen error: %s", err)
+		}
+		f, _ := ln.(*net.TCPListener).File()
+		if err := unix.Dup2(int(f.Fd()), start+i); err != nil {
+			t.Fatalf("Dup2 error: %s", err)
+		}
+		_ = f.Close()
+		_ = ln.Close()
+	}
+
+	os.Setenv(sdListenPidEnv, strconv.Itoa(os.Getpid()))
+	os.Setenv(sdListenFdsEnv, "2")
+	os.Setenv(sdListenFdNamesEnv, "http:")
+
+	s := New()
+	listeners, err := s.systemdListeners(start)
+	if err != nil {
+		t.Fatalf("systemdListeners error: %s", err)
+	}
+	defer func() {
+		for _, ln := range listeners {
+			_ = ln.Close()
+		}
+	}()
+
+	if len(listeners) != 2 {
+		t.Fatalf("Got: %d, Expect: 2", len(listeners))
+	}
+	ln := s.ListenersByName("http")
+	if len(ln) != 1 || ln[0].Addr().String() != listeners[0].Addr().String() {
+		t.Fatalf("Got: %v, Expect: %v", ln, listeners[:1])
+	}
+	ln = s.ListenersByName(sdUnknownName)
+	if len(ln) != 1 || ln[0].Addr().String() != listeners[1].Addr().String() {
+		t.Fatalf("Got: %v, Expect: %v", ln, listeners[1:])
+	}
+	if _, found := os.LookupEnv(sdListenFdsEnv); found {
+		t.Fatalf("%s not unset", sdListenFdsEnv)
+	}
+
+	// The fds are passed to the other process.
+	os.Setenv(sdListenPidEnv, "1")
+	os.Setenv(sdListenFdsEnv, "2")
+	defer os.Unsetenv(sdListenPidEnv)
+	defer os.Unsetenv(sdListenFdsEnv)
+	if listeners, _ := New().systemdListeners(start); listeners != nil {
+		t.Fatalf("Got: %v, Expect: nil", listeners)
+	}
+}
@@ -1,6 +1,7 @@
 package master
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net"
@@ -16,7 +17,12 @@ type TcpService struct {
 	AcceptHandler AcceptFunc
 	CloseHandler  CloseFunc
 
+	server    *Server
 	listeners []net.Listener
+	loops     sync.WaitGroup // the goroutines accepting the connections
+
+	connMutex sync.Mutex
+	conns     map[net.Conn]struct{}
 }
 
 func (service *TcpService) handleConn(conn net.Conn) {
@@ -24,7 +30,7 @@ func (service *TcpService) handleConn(conn net.Conn) {
 		panic("acceptHandler nil")
 	}
 
-	ConnCountInc()
+	service.server.ConnCountInc()
 
 	service.AcceptHandler(conn)
 
@@ -34,7 +40,56 @@ func (service *TcpService) handleConn(conn net.Conn) {
 
 	_ = conn.Close()
 
-	ConnCountDec()
+	service.delConn(conn)
+	service.server.ConnCountDec()
+}
+
+func (service *TcpService) addConn(conn net.Conn) {
+	service.connMutex.Lock()
+	if service.conns == nil {
+		service.conns = make(map[net.Conn]struct{})
+	}
+	service.conns[conn] = struct{}{}
+	service.connMutex.Unlock()
+}
+
+func (service *TcpService) delConn(conn net.Conn) {
+	service.connMutex.Lock()
+	delete(service.conns, conn)
+	service.connMutex.Unlock()
+}
+
+func (service *TcpService) connCount() int {
+	service.connMutex.Lock()
+	defer service.connMutex.Unlock()
+	return len(service.conns)
+}
+
+// drain waits for the connections being handled until ctx is done, then
+// closes the rest by force.
+func (service *TcpService) drain(ctx context.Context) int {
+	for _, ln := range service.listeners {
+		_ = ln.Close()
+	}
+
+	// The connections accepted just before the listeners were closed are
+	// added when the accepting goroutines return.
+	service.loops.Wait()
+
+	n := waitConns(ctx, service.connCount)
+	if n == 0 {
+		return 0
+	}
+
+	service.connMutex.Lock()
+	n = len(service.conns)
+	for conn := range service.conns {
+		_ = conn.Close()
+	}
+	service.connMutex.Unlock()
+
+	log.Printf("pid=%d: %d connections closed by force", os.Getpid(), n)
+	return n
 }
 
 func (service *TcpService) loopAccept(ln net.Listener) {
@@ -42,31 +97,41 @@ func (service *TcpService) loopAccept(ln net.Listener) {
 		conn, err := ln.Accept()
 		if err != nil {
 			log.Println("Accept error", err)
-			time.Sleep(1000 * time.Millisecond)
+			if !service.server.Stopping() {
+				time.Sleep(1000 * time.Millisecond)
+			}
 			break
 		}
 
+		service.addConn(conn)
 		go service.handleConn(conn)
 	}
 
-	// Which is inited and changed in service.go, when the monitorMaster
-	// fiber testing the disconnecting with acl_master, the stopping will
-	// be set true and the listeners will all be closed there.
-	if stopping {
+	// Which is changed in server.go, when the monitorMaster fiber testing
+	// the disconnecting with acl_master, the stopping will be set true and
+	// the listeners will all be closed there.
+	if service.server.Stopping() {
 		log.Println("server stopping")
 	} else {
 		log.Println("server failed")
 	}
 }
 
+// Run runs the service until it's stopped.
 func (service *TcpService) Run() {
-	var g sync.WaitGroup
-	g.Add(len(service.listeners))
+	_ = service.RunContext(context.Background())
+}
+
+// RunContext runs the service until it's stopped or ctx is done. When ctx
+// is done, the service is shut down and the connections are waited for at
+// most app_wait_limit seconds, the error of Shutdown is returned.
+func (service *TcpService) RunContext(ctx context.Context) error {
+	service.loops.Add(len(service.listeners))
 
 	for _, ln := range service.listeners {
 		// Create fiber for each listener to accept connections.
 		go func(l net.Listener) {
-			defer g.Done()
+			defer service.loops.Done()
 
 			service.loopAccept(l)
 		}(ln)
@@ -74,14 +139,28 @@ func (service *TcpService) Run() {
 
 	log.Println("service started!")
 
-	// Waiting for service been stopped called in service.go
-	res := Wait()
+	var err error
+	select {
+	case <-ctx.Done():
+		dctx, cancel := service.server.drainContext()
+		err = service.server.Shutdown(dctx)
+		cancel()
+	case <-service.server.done:
+	}
+
+	// Waiting for service been stopped called in server.go
+	res := service.server.Wait()
+
+	// The listeners are still open if Stop was called directly.
+	for _, ln := range service.listeners {
+		_ = ln.Close()
+	}
 
 	// Waiting all services done.
-	g.Wait()
+	service.loops.Wait()
 
-	if exitHandler != nil {
-		exitHandler()
+	if service.server.exitHandler != nil {
+		service.server.exitHandler()
 	}
 
 	if res {
@@ -89,15 +168,29 @@ func (service *TcpService) Run() {
 	} else {
 		log.Println("service stopped abnormal!")
 	}
+	return err
 }
 
-func TcpServiceInit(addrs string) (*TcpService, error) {
-	listeners, err := ServiceInit(addrs)
+// Shutdown shuts down the Server of the service, see Server.Shutdown.
+func (service *TcpService) Shutdown(ctx context.Context) error {
+	return service.server.Shutdown(ctx)
+}
+
+// TcpServiceInit creates the TCP service of the Server with the specified
+// listening addrs.
+func (s *Server) TcpServiceInit(addrs string) (*TcpService, error) {
+	listeners, err := s.ServiceInit(addrs)
 	if err != nil {
 		log.Println("ServiceInit failed:", err)
 		return nil, err
 	}
-	return &TcpService{listeners: listeners}, nil
+	service := &TcpService{server: s, listeners: listeners}
+	s.addDrainer(service)
+	return service, nil
+}
+
+func TcpServiceInit(addrs string) (*TcpService, error) {
+	return defaultServer.TcpServiceInit(addrs)
 }
 
 var (
@@ -0,0 +1,232 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package master
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// inheritFdsEnv gives the fds of the listeners inherited from the old
+	// process when upgrading, such as "4,5".
+	inheritFdsEnv = "GO_SERVICE_INHERIT_FDS"
+	// readyFdEnv gives the fd of the pipe used to tell the old process
+	// that the new one is ready.
+	readyFdEnv = "GO_SERVICE_READY_FD"
+
+	upgradeReadyFd = 3
+	upgradeTimeout = 30 * time.Second
+)
+
+// Upgrade starts the program again with the listeners of the Server, which
+// may be a new binary, and waits for the new process to be ready, then
+// shuts down the Server. It's triggered by SIGUSR2 in alone mode, so the
+// binary can be replaced without dropping the listening sockets.
+func (s *Server) Upgrade() error {
+	if !atomic.CompareAndSwapInt32(&s.upgrading, 0, 1) {
+		return errors.New("upgrading already")
+	}
+	defer atomic.StoreInt32(&s.upgrading, 0)
+
+	if s.Stopping() {
+		return errors.New("server stopping")
+	}
+	if len(s.listeners) == 0 {
+		return errors.New("no listener to pass")
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// The fd of the ready pipe is 3, and the listeners follow it.
+	files := []*os.File{w}
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	fds := make([]string, 0, len(s.listeners))
+	for _, ln := range s.listeners {
+		f, err := listenerFile(ln)
+		if err != nil {
+			return fmt.Errorf("listener %s: %s", ln.Addr(), err)
+		}
+		files = append(files, f)
+		fds = append(fds, strconv.Itoa(upgradeReadyFd+len(fds)+1))
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		inheritFdsEnv+"="+strings.Join(fds, ","),
+		readyFdEnv+"="+strconv.Itoa(upgradeReadyFd))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+
+	// The sockets were set to blocking mode when being passed, which are
+	// shared with the listeners, so set them back, or Accept may block
+	// when the connection is accepted by the new process.
+	for _, ln := range s.listeners {
+		setNonblock(ln)
+	}
+	if err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	// Close the write end, so the read end gets EOF if the new process
+	// exits before being ready.
+	_ = w.Close()
+	files = files[1:]
+
+	_ = r.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		// Kill the new process, or it serves with the listeners together
+		// with the Server.
+		_ = cmd.Process.Kill()
+		<-exited
+		return fmt.Errorf("new process pid=%d not ready: %s", cmd.Process.Pid, err)
+	}
+	log.Printf("pid=%d: new process pid=%d ready", os.Getpid(), cmd.Process.Pid)
+
+	// The unix domain sockets are used by the new process now.
+	for _, ln := range s.listeners {
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+
+	go s.shutdownDrain()
+	return nil
+}
+
+// listenerFile returns the dupped file of the listener.
+func listenerFile(ln net.Listener) (*os.File, error) {
+	switch l := ln.(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UnixListener:
+		return l.File()
+	default:
+		return nil, fmt.Errorf("unsupported listener %T", ln)
+	}
+}
+
+func setNonblock(ln net.Listener) {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+	_ = rc.Control(func(fd uintptr) {
+		_ = syscall.SetNonblock(int(fd), true)
+	})
+}
+
+// watchUpgrade upgrades the program when receiving SIGUSR2, until the
+// Server is stopped.
+func (s *Server) watchUpgrade() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				log.Printf("pid=%d: got SIGUSR2, upgrading", os.Getpid())
+				if err := s.Upgrade(); err != nil {
+					log.Printf("pid=%d: upgrade error: %s", os.Getpid(), err)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// inheritedListeners returns the listeners inherited from the old process
+// when upgrading, or nil if not being upgraded.
+func inheritedListeners() ([]net.Listener, error) {
+	value, found := os.LookupEnv(inheritFdsEnv)
+	if !found {
+		return nil, nil
+	}
+	_ = os.Unsetenv(inheritFdsEnv)
+
+	listeners := []net.Listener(nil)
+	for _, token := range strings.Split(value, ",") {
+		fd, err := strconv.Atoi(strings.TrimSpace(token))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s=%s", inheritFdsEnv, value)
+		}
+
+		file := os.NewFile(uintptr(fd), "inherited listen fd")
+		ln, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited fd %d: %s", fd, err)
+		}
+		// The socket file is removed on exit just like the one listened
+		// by the process itself.
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(true)
+		}
+
+		log.Printf("pid=%d: inherit %s from fd %d", os.Getpid(), ln.Addr(), fd)
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// notifyReady tells the old process that the new one is ready when
+// upgrading.
+func notifyReady() {
+	value, found := os.LookupEnv(readyFdEnv)
+	if !found {
+		return
+	}
+	_ = os.Unsetenv(readyFdEnv)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("pid=%d: invalid %s=%s", os.Getpid(), readyFdEnv, value)
+		return
+	}
+
+	file := os.NewFile(uintptr(fd), "ready pipe")
+	if _, err := file.Write([]byte{1}); err != nil {
+		log.Printf("pid=%d: notify ready error: %s", os.Getpid(), err)
+	}
+	_ = file.Close()
+}
@@ -1,19 +1,25 @@
 package master
 
 import (
+	"context"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 type WebService struct {
+	server        *Server
 	listeners     []net.Listener
 	webServs      []*http.Server
 	handler       http.Handler
 	AcceptHandler AcceptFunc
 	CloseHandler  CloseFunc
+
+	connMutex sync.Mutex
+	conns     map[net.Conn]struct{}
 }
 
 func pathExist(path string) bool {
@@ -24,20 +30,22 @@ func pathExist(path string) bool {
 	return true
 }
 
-func (service *WebService) webServ(ln net.Listener) {
-	serv := &http.Server{
+func (service *WebService) newServer() *http.Server {
+	return &http.Server{
 		Handler: service.handler,
 		ConnState: func(conn net.Conn, state http.ConnState) {
 			switch state {
 			case http.StateNew:
-				ConnCountInc()
+				service.addConn(conn)
+				service.server.ConnCountInc()
 				if service.AcceptHandler != nil {
 					service.AcceptHandler(conn)
 				}
 			case http.StateActive:
 			case http.StateIdle:
 			case http.StateClosed, http.StateHijacked:
-				ConnCountDec()
+				service.delConn(conn)
+				service.server.ConnCountDec()
 				if service.CloseHandler != nil {
 					service.CloseHandler(conn)
 				}
@@ -45,43 +53,128 @@ func (service *WebService) webServ(ln net.Listener) {
 			}
 		},
 	}
+}
 
-	service.webServs = append(service.webServs, serv)
-
-	if len(TlsCertFile) > 0 && len(TlsKeyFile) > 0 &&
-		pathExist(TlsCertFile) && pathExist(TlsKeyFile) {
+func (service *WebService) webServ(serv *http.Server, ln net.Listener) {
+	st := service.server.getSettings()
+	if len(st.tlsCertFile) > 0 && len(st.tlsKeyFile) > 0 &&
+		pathExist(st.tlsCertFile) && pathExist(st.tlsKeyFile) {
 
-		_ = serv.ServeTLS(ln, TlsCertFile, TlsKeyFile)
+		_ = serv.ServeTLS(ln, st.tlsCertFile, st.tlsKeyFile)
 	} else {
 		_ = serv.Serve(ln)
 	}
 }
 
-// WebServiceStart start WEB service with the specified listening addrs
+func (service *WebService) addConn(conn net.Conn) {
+	service.connMutex.Lock()
+	if service.conns == nil {
+		service.conns = make(map[net.Conn]struct{})
+	}
+	service.conns[conn] = struct{}{}
+	service.connMutex.Unlock()
+}
+
+func (service *WebService) delConn(conn net.Conn) {
+	service.connMutex.Lock()
+	delete(service.conns, conn)
+	service.connMutex.Unlock()
+}
+
+func (service *WebService) getServs() []*http.Server {
+	service.connMutex.Lock()
+	defer service.connMutex.Unlock()
+	return service.webServs
+}
+
+// drain shuts down the web servers which close the idle connections and
+// wait for the active ones until ctx is done, then closes the rest by force.
+func (service *WebService) drain(ctx context.Context) int {
+	servs := service.getServs()
+
+	var (
+		g      sync.WaitGroup
+		failed int32
+	)
+	g.Add(len(servs))
+	for _, serv := range servs {
+		go func(serv *http.Server) {
+			defer g.Done()
+			if serv.Shutdown(ctx) != nil {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(serv)
+	}
+	g.Wait()
+
+	// The closed connections may be still in conns until their ConnState
+	// hooks are called, so only count them when Shutdown failed.
+	if failed == 0 {
+		return 0
+	}
+
+	service.connMutex.Lock()
+	n := len(service.conns)
+	service.connMutex.Unlock()
+
+	for _, serv := range servs {
+		_ = serv.Close()
+	}
+	log.Printf("pid=%d: %d connections closed by force", os.Getpid(), n)
+	return n
+}
+
+// Run runs the service until it's stopped.
 func (service *WebService) Run() {
+	_ = service.RunContext(context.Background())
+}
+
+// RunContext runs the service until it's stopped or ctx is done. When ctx
+// is done, the service is shut down and the connections are waited for at
+// most app_wait_limit seconds, the error of Shutdown is returned.
+func (service *WebService) RunContext(ctx context.Context) error {
 	var g sync.WaitGroup // Used to wait for service to stop.
 
 	g.Add(len(service.listeners))
 
+	service.connMutex.Lock()
 	for _, ln := range service.listeners {
+		serv := service.newServer()
+		service.webServs = append(service.webServs, serv)
+
 		// Create fiber for each listener to accept client connection.
 		go func(l net.Listener) {
 			defer g.Done()
 
-			service.webServ(l)
+			service.webServ(serv, l)
 		}(ln)
 	}
+	service.connMutex.Unlock()
 
 	log.Println("webservice started!")
 
-	// Call Wait() in service.go to wait the end of the service.
-	res := Wait()
+	var err error
+	select {
+	case <-ctx.Done():
+		dctx, cancel := service.server.drainContext()
+		err = service.server.Shutdown(dctx)
+		cancel()
+	case <-service.server.done:
+	}
+
+	// Call Wait() in server.go to wait the end of the service.
+	res := service.server.Wait()
+
+	// The listeners are still open if Stop was called directly.
+	for _, ln := range service.listeners {
+		_ = ln.Close()
+	}
 
 	// Waiting all the web listening services done.
 	g.Wait()
 
-	if exitHandler != nil {
-		exitHandler()
+	if service.server.exitHandler != nil {
+		service.server.exitHandler()
 	}
 
 	if res {
@@ -89,16 +182,31 @@ func (service *WebService) Run() {
 	} else {
 		log.Printf("pid=%d, webservice stopped abnormal!\r\n", os.Getpid())
 	}
+	return err
 }
 
-func WebServiceInit(addrs string, handler http.Handler) (*WebService, error) {
-	listeners, err := ServiceInit(addrs)
+// Shutdown shuts down the Server of the service, see Server.Shutdown.
+func (service *WebService) Shutdown(ctx context.Context) error {
+	return service.server.Shutdown(ctx)
+}
+
+// WebServiceInit creates the WEB service of the Server with the specified
+// listening addrs.
+func (s *Server) WebServiceInit(addrs string, handler http.Handler) (*WebService, error) {
+	listeners, err := s.ServiceInit(addrs)
 	if err != nil {
 		log.Println("ServiceInit failed:", err)
 		return nil, err
 	}
 
-	return &WebService{listeners: listeners, handler: handler}, nil
+	service := &WebService{server: s, listeners: listeners,
+		handler: handler}
+	s.addDrainer(service)
+	return service, nil
+}
+
+func WebServiceInit(addrs string, handler http.Handler) (*WebService, error) {
+	return defaultServer.WebServiceInit(addrs, handler)
 }
 
 // WebServiceStart start WEB service with the specified listening addrs
@@ -112,21 +220,3 @@ func WebServiceStart(addrs string, handler http.Handler) error {
 	service.Run()
 	return nil
 }
-
-/*
-func onWebStop() {
-	var wg sync.WaitGroup
-
-	wg.Add(len(webServers))
-
-	for _, ln := range webServers {
-		ln := ln
-		go func() {
-			defer wg.Done()
-			_ = ln.Shutdown(context.Background())
-		}()
-	}
-
-	wg.Wait()
-}
-*/